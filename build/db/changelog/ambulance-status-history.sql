@@ -0,0 +1,44 @@
+-- every period an ambulance spent in each status, the current period is left open
+CREATE TABLE ambulance_status_history
+(
+    history_id   SERIAL PRIMARY KEY,
+    ambulance_id INT              NOT NULL REFERENCES ambulances (ambulance_id) ON DELETE CASCADE,
+    status       ambulance_status NOT NULL,
+    started_at   TIMESTAMP        NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ended_at     TIMESTAMP
+);
+
+CREATE INDEX idx_ambulance_status_history_ambulance_id ON ambulance_status_history (ambulance_id, started_at);
+CREATE INDEX idx_ambulance_status_history_period ON ambulance_status_history (started_at, ended_at);
+
+CREATE FUNCTION record_ambulance_status() RETURNS TRIGGER AS
+$$
+BEGIN
+    IF TG_OP = 'UPDATE' AND NEW.status IS NOT DISTINCT FROM OLD.status THEN
+        RETURN NEW;
+    END IF;
+
+    UPDATE ambulance_status_history
+    SET ended_at = CURRENT_TIMESTAMP
+    WHERE ambulance_id = NEW.ambulance_id
+      AND ended_at IS NULL;
+
+    IF NEW.status IS NOT NULL THEN
+        INSERT INTO ambulance_status_history (ambulance_id, status)
+        VALUES (NEW.ambulance_id, NEW.status);
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER ambulances_status_history
+    AFTER INSERT OR UPDATE OF status
+    ON ambulances
+    FOR EACH ROW
+EXECUTE FUNCTION record_ambulance_status();
+
+INSERT INTO ambulance_status_history (ambulance_id, status)
+SELECT ambulance_id, status
+FROM ambulances
+WHERE status IS NOT NULL;
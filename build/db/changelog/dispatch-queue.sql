@@ -0,0 +1,5 @@
+ALTER TABLE ambulance_requests
+    ADD COLUMN claimed_by    VARCHAR(100),
+    ADD COLUMN claimed_until TIMESTAMP;
+
+CREATE INDEX idx_ambulance_requests_pending ON ambulance_requests (hospital_id, created_at) WHERE status = 'PENDING';
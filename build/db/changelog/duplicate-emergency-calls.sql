@@ -0,0 +1,16 @@
+-- great-circle distance in metres between two {"latitude", "longitude"} locations
+CREATE OR REPLACE FUNCTION location_distance(a JSONB, b JSONB) RETURNS DOUBLE PRECISION AS
+$$
+SELECT 2 * 6371000 * asin(sqrt(
+        power(sin(radians(((b ->> 'latitude')::float8 - (a ->> 'latitude')::float8) / 2)), 2) +
+        cos(radians((a ->> 'latitude')::float8)) * cos(radians((b ->> 'latitude')::float8)) *
+        power(sin(radians(((b ->> 'longitude')::float8 - (a ->> 'longitude')::float8) / 2)), 2)
+    ))
+$$ LANGUAGE sql IMMUTABLE;
+
+ALTER TABLE emergency_calls
+    ADD COLUMN parent_call_id INT REFERENCES emergency_calls (call_id) ON DELETE SET NULL;
+
+CREATE INDEX idx_emergency_calls_call_time ON emergency_calls (call_time);
+CREATE INDEX idx_emergency_calls_parent_call_id ON emergency_calls (parent_call_id);
+CREATE INDEX idx_emergency_calls_medical_condition_trgm ON emergency_calls USING GIN (lower(medical_condition) gin_trgm_ops);
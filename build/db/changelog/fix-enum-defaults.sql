@@ -0,0 +1,5 @@
+-- databases created before initial.sql was corrected have defaults written as display names, which are not
+-- labels of their enums. This is a no-op on databases created since.
+ALTER TABLE emergency_calls ALTER COLUMN severity SET DEFAULT 'LOW';
+ALTER TABLE emergency_calls ALTER COLUMN status SET DEFAULT 'AMBULANCE_PENDING';
+ALTER TABLE ambulances ALTER COLUMN status SET DEFAULT 'AVAILABLE';
@@ -0,0 +1,10 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE EXTENSION IF NOT EXISTS fuzzystrmatch;
+
+CREATE INDEX IF NOT EXISTS idx_patients_first_name_trgm ON patients USING GIN (lower(first_name) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_patients_last_name_trgm ON patients USING GIN (lower(last_name) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_patients_address_trgm ON patients USING GIN (lower(address) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_patients_first_name_dmetaphone ON patients (dmetaphone(first_name));
+CREATE INDEX IF NOT EXISTS idx_patients_last_name_dmetaphone ON patients (dmetaphone(last_name));
+CREATE INDEX IF NOT EXISTS idx_patients_date_of_birth ON patients (date_of_birth);
+CREATE INDEX IF NOT EXISTS idx_patients_phone_number_digits ON patients (right(regexp_replace(coalesce(phone_number, ''), '\D', '', 'g'), 10));
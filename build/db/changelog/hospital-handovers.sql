@@ -0,0 +1,13 @@
+CREATE TABLE hospital_handovers
+(
+    handover_id    SERIAL PRIMARY KEY,
+    request_id     INT   NOT NULL REFERENCES ambulance_requests (request_id) ON DELETE CASCADE,
+    hospital_id    INT   NOT NULL REFERENCES regional_hospitals (hospital_id),
+    call_id        INT   NOT NULL REFERENCES emergency_calls (call_id) ON DELETE CASCADE,
+    patient_id     INT REFERENCES patients (patient_id) ON DELETE SET NULL,
+    package        JSONB NOT NULL,
+    handed_over_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_hospital_handovers_hospital_id ON hospital_handovers (hospital_id, handed_over_at);
+CREATE UNIQUE INDEX idx_hospital_handovers_request_id ON hospital_handovers (request_id);
@@ -0,0 +1,34 @@
+CREATE TYPE incident_status AS ENUM ('DECLARED', 'STOOD_DOWN');
+
+CREATE TABLE incidents
+(
+    incident_id    SERIAL PRIMARY KEY,
+    name           VARCHAR(100) NOT NULL,
+    description    TEXT,
+    location       JSONB,
+    severity       injury_severity NOT NULL,
+    status         incident_status DEFAULT 'DECLARED',
+    declared_by    VARCHAR(100),
+    declared_at    TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    stood_down_at  TIMESTAMP
+);
+
+CREATE TABLE incident_events
+(
+    event_id     SERIAL PRIMARY KEY,
+    incident_id  INT         NOT NULL REFERENCES incidents (incident_id) ON DELETE CASCADE,
+    event_type   VARCHAR(50) NOT NULL,
+    description  TEXT,
+    call_id      INT REFERENCES emergency_calls (call_id) ON DELETE SET NULL,
+    request_id   INT REFERENCES ambulance_requests (request_id) ON DELETE SET NULL,
+    ambulance_id INT REFERENCES ambulances (ambulance_id) ON DELETE SET NULL,
+    recorded_by  VARCHAR(100),
+    occurred_at  TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- requests and patients belong to an incident through its calls
+ALTER TABLE emergency_calls
+    ADD COLUMN incident_id INT REFERENCES incidents (incident_id) ON DELETE SET NULL;
+
+CREATE INDEX idx_emergency_calls_incident_id ON emergency_calls (incident_id);
+CREATE INDEX idx_incident_events_incident_id ON incident_events (incident_id, occurred_at);
@@ -53,8 +53,8 @@ CREATE TABLE emergency_calls
     call_time             TIMESTAMP   DEFAULT CURRENT_TIMESTAMP,
     medical_condition     TEXT,
     location              TEXT,
-    severity              injury_severity DEFAULT 'Low',
-    status                emergency_call_status DEFAULT 'Pending',
+    severity              injury_severity DEFAULT 'LOW',
+    status                emergency_call_status DEFAULT 'AMBULANCE_PENDING'
 );
 
 CREATE TABLE ambulances
@@ -62,7 +62,7 @@ CREATE TABLE ambulances
     ambulance_id         SERIAL PRIMARY KEY,
     ambulance_number     VARCHAR(20) UNIQUE NOT NULL,
     current_location     JSONB,
-    status               ambulance_status DEFAULT 'Available',
+    status               ambulance_status DEFAULT 'AVAILABLE',
     regional_hospital_id INT REFERENCES regional_hospitals (hospital_id)
 );
 
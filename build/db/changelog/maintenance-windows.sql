@@ -0,0 +1,20 @@
+CREATE TYPE maintenance_status AS ENUM ('SCHEDULED', 'DEFERRED', 'IN_PROGRESS', 'COMPLETED', 'CANCELLED');
+
+CREATE TABLE maintenance_windows
+(
+    window_id     SERIAL PRIMARY KEY,
+    ambulance_id  INT       NOT NULL REFERENCES ambulances (ambulance_id) ON DELETE CASCADE,
+    planned_start TIMESTAMP NOT NULL,
+    planned_end   TIMESTAMP NOT NULL,
+    reason        TEXT,
+    odometer      INT,
+    status        maintenance_status DEFAULT 'SCHEDULED',
+    started_at    TIMESTAMP,
+    completed_at  TIMESTAMP,
+    created_at    TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (planned_end > planned_start)
+);
+
+CREATE INDEX idx_maintenance_windows_ambulance_id ON maintenance_windows (ambulance_id);
+CREATE INDEX idx_maintenance_windows_due ON maintenance_windows (planned_start)
+    WHERE status IN ('SCHEDULED', 'DEFERRED');
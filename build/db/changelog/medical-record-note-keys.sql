@@ -0,0 +1,9 @@
+-- idempotency keys of appended notes, so a retried append is recorded once while the same note written again
+-- later is still kept
+CREATE TABLE medical_record_note_keys
+(
+    patient_id      INT          NOT NULL REFERENCES patients (patient_id) ON DELETE CASCADE,
+    idempotency_key VARCHAR(100) NOT NULL,
+    created_at      TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (patient_id, idempotency_key)
+);
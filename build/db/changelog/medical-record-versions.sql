@@ -0,0 +1,85 @@
+-- versions deliberately have no foreign keys so history survives patient merges and deletions
+CREATE TABLE medical_record_versions
+(
+    version_id   SERIAL PRIMARY KEY,
+    record_id    INT       NOT NULL,
+    patient_id   INT       NOT NULL,
+    version      INT       NOT NULL,
+    callout_ids  INT[],
+    conditions   TEXT[],
+    medications  TEXT[],
+    allergies    TEXT[],
+    notes        TEXT[],
+    changed_by   TEXT,
+    changed_at   TIMESTAMP NOT NULL DEFAULT clock_timestamp(),
+    UNIQUE (record_id, version)
+);
+
+CREATE INDEX idx_medical_record_versions_patient_changed_at ON medical_record_versions (patient_id, changed_at);
+
+-- snapshot every insert and update of a medical record, who made the change is taken from the
+-- kwikmedical.changed_by setting of the transaction and falls back to the database user
+CREATE FUNCTION record_medical_record_version() RETURNS TRIGGER AS
+$$
+BEGIN
+    IF TG_OP = 'UPDATE' AND
+       NEW.patient_id IS NOT DISTINCT FROM OLD.patient_id AND
+       NEW.callout_ids IS NOT DISTINCT FROM OLD.callout_ids AND
+       NEW.conditions IS NOT DISTINCT FROM OLD.conditions AND
+       NEW.medications IS NOT DISTINCT FROM OLD.medications AND
+       NEW.allergies IS NOT DISTINCT FROM OLD.allergies AND
+       NEW.notes IS NOT DISTINCT FROM OLD.notes THEN
+        RETURN NEW;
+    END IF;
+
+    INSERT INTO medical_record_versions (record_id, patient_id, version, callout_ids, conditions, medications,
+                                         allergies, notes, changed_by)
+    SELECT NEW.record_id,
+           NEW.patient_id,
+           coalesce(max(version), 0) + 1,
+           NEW.callout_ids,
+           NEW.conditions,
+           NEW.medications,
+           NEW.allergies,
+           NEW.notes,
+           coalesce(nullif(current_setting('kwikmedical.changed_by', true), ''), current_user)
+    FROM medical_record_versions
+    WHERE record_id = NEW.record_id;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER medical_records_versioning
+    AFTER INSERT OR UPDATE
+    ON medical_records
+    FOR EACH ROW
+EXECUTE FUNCTION record_medical_record_version();
+
+CREATE FUNCTION prevent_medical_record_version_changes() RETURNS TRIGGER AS
+$$
+BEGIN
+    RAISE EXCEPTION 'medical record versions are immutable';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER medical_record_versions_immutable
+    BEFORE UPDATE OR DELETE
+    ON medical_record_versions
+    FOR EACH ROW
+EXECUTE FUNCTION prevent_medical_record_version_changes();
+
+-- existing records become their first version
+INSERT INTO medical_record_versions (record_id, patient_id, version, callout_ids, conditions, medications, allergies,
+                                     notes, changed_by, changed_at)
+SELECT record_id,
+       patient_id,
+       1,
+       callout_ids,
+       conditions,
+       medications,
+       allergies,
+       notes,
+       'migration',
+       coalesce(last_updated, CURRENT_TIMESTAMP)
+FROM medical_records;
@@ -0,0 +1,87 @@
+-- callouts are linked to a patient through call_out_details.call_id -> emergency_calls.patient_id rather
+-- than the unchecked medical_records.callout_ids array
+
+-- link calls that were only associated with a patient through the array
+UPDATE emergency_calls ec
+SET patient_id = mr.patient_id
+FROM medical_records mr,
+     unnest(mr.callout_ids) AS callout(detail_id),
+     call_out_details cod
+WHERE cod.detail_id = callout.detail_id
+  AND ec.call_id = cod.call_id
+  AND ec.patient_id IS NULL;
+
+-- every id in the arrays has to be reachable through its call once they are dropped, so the migration stops on
+-- callouts that do not exist, have no call, or whose call is linked to another patient rather than lose them
+DO
+$$
+DECLARE
+    conflicts TEXT;
+BEGIN
+    SELECT string_agg(format('record %s callout %s (%s)', mr.record_id, callout.detail_id,
+                             CASE
+                                 WHEN cod.detail_id IS NULL THEN 'does not exist'
+                                 WHEN cod.call_id IS NULL THEN 'has no call'
+                                 ELSE 'call ' || ec.call_id || ' is linked to patient ' || ec.patient_id
+                                 END), ', ')
+    INTO conflicts
+    FROM medical_records mr
+             CROSS JOIN unnest(mr.callout_ids) AS callout(detail_id)
+             LEFT JOIN call_out_details cod ON cod.detail_id = callout.detail_id
+             LEFT JOIN emergency_calls ec ON ec.call_id = cod.call_id
+    WHERE cod.detail_id IS NULL
+       OR cod.call_id IS NULL
+       OR ec.patient_id IS DISTINCT FROM mr.patient_id;
+
+    IF conflicts IS NOT NULL THEN
+        RAISE EXCEPTION 'medical_records.callout_ids cannot be linked through emergency calls: %', conflicts;
+    END IF;
+
+    IF EXISTS (SELECT 1 FROM call_out_details WHERE call_id IS NULL) THEN
+        RAISE EXCEPTION 'call_out_details has callouts without a call, they cannot be linked to a patient';
+    END IF;
+END;
+$$;
+
+-- callouts can only reach a patient through their call
+ALTER TABLE call_out_details
+    ALTER COLUMN call_id SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_call_out_details_call_id ON call_out_details (call_id);
+CREATE INDEX IF NOT EXISTS idx_emergency_calls_patient_id ON emergency_calls (patient_id);
+
+CREATE OR REPLACE FUNCTION record_medical_record_version() RETURNS TRIGGER AS
+$$
+BEGIN
+    IF TG_OP = 'UPDATE' AND
+       NEW.patient_id IS NOT DISTINCT FROM OLD.patient_id AND
+       NEW.conditions IS NOT DISTINCT FROM OLD.conditions AND
+       NEW.medications IS NOT DISTINCT FROM OLD.medications AND
+       NEW.allergies IS NOT DISTINCT FROM OLD.allergies AND
+       NEW.notes IS NOT DISTINCT FROM OLD.notes THEN
+        RETURN NEW;
+    END IF;
+
+    INSERT INTO medical_record_versions (record_id, patient_id, version, conditions, medications, allergies, notes,
+                                         changed_by)
+    SELECT NEW.record_id,
+           NEW.patient_id,
+           coalesce(max(version), 0) + 1,
+           NEW.conditions,
+           NEW.medications,
+           NEW.allergies,
+           NEW.notes,
+           coalesce(nullif(current_setting('kwikmedical.changed_by', true), ''), current_user)
+    FROM medical_record_versions
+    WHERE record_id = NEW.record_id;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- versions are immutable history, so the callouts they were written with are kept as they were
+COMMENT ON COLUMN medical_record_versions.callout_ids IS
+    'callouts of versions written before callouts were linked through emergency calls, NULL since';
+
+ALTER TABLE medical_records
+    DROP COLUMN callout_ids;
@@ -0,0 +1,31 @@
+-- NHS numbers are stored as bare digits so that "943 476 5919" and "9434765919" match. Patients recorded under
+-- more than one form of the same number would collide on the unique constraint, so they are reported and the
+-- migration stops until they have been merged by hand.
+DO
+$$
+    DECLARE
+        collisions TEXT;
+    BEGIN
+        SELECT string_agg(normalized || ' (patients ' || patient_ids || ')', ', ')
+        INTO collisions
+        FROM (SELECT regexp_replace(nhs_number, '[\s-]', '', 'g') AS normalized,
+                     string_agg(patient_id::text, ', ' ORDER BY patient_id) AS patient_ids
+              FROM patients
+              GROUP BY 1
+              HAVING count(*) > 1) duplicated;
+
+        IF collisions IS NOT NULL THEN
+            RAISE EXCEPTION 'patients share an NHS number once spacing is removed: %', collisions;
+        END IF;
+    END
+$$;
+
+UPDATE patients
+SET nhs_number = regexp_replace(nhs_number, '[\s-]', '', 'g')
+WHERE nhs_number ~ '[\s-]';
+
+UPDATE emergency_calls
+SET nhs_number = regexp_replace(nhs_number, '[\s-]', '', 'g')
+WHERE nhs_number ~ '[\s-]';
+
+CREATE INDEX IF NOT EXISTS idx_emergency_calls_nhs_number ON emergency_calls (nhs_number);
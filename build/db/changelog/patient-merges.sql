@@ -0,0 +1,34 @@
+CREATE TYPE duplicate_status AS ENUM ('PROPOSED', 'MERGED', 'DISMISSED');
+
+-- proposals have no foreign keys so that they outlive a merge and stop the pair from being proposed again
+CREATE TABLE duplicate_patient_proposals
+(
+    proposal_id    SERIAL PRIMARY KEY,
+    patient_id     INT NOT NULL,
+    duplicate_id   INT NOT NULL,
+    confidence     DOUBLE PRECISION,
+    matched_fields TEXT[],
+    status         duplicate_status DEFAULT 'PROPOSED',
+    created_at     TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at     TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (patient_id, duplicate_id)
+);
+
+-- survivor_id and duplicate_id deliberately have no foreign keys, the duplicate no longer exists once merged
+CREATE TABLE patient_merges
+(
+    merge_id          SERIAL PRIMARY KEY,
+    survivor_id       INT NOT NULL,
+    duplicate_id      INT NOT NULL,
+    duplicate_patient JSONB NOT NULL,
+    duplicate_records JSONB NOT NULL,
+    survivor_record   JSONB NOT NULL,
+    merged_record_id  INT,
+    moved_call_ids    INT[],
+    merged_at         TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at        TIMESTAMP NOT NULL,
+    reverted_at       TIMESTAMP
+);
+
+CREATE INDEX idx_patient_merges_survivor_id ON patient_merges (survivor_id);
+CREATE INDEX idx_patient_merges_duplicate_id ON patient_merges (duplicate_id);
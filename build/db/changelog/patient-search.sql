@@ -0,0 +1,4 @@
+-- keyset pagination indexes, matching the sort orders offered by SearchPatients
+CREATE INDEX IF NOT EXISTS idx_patients_name_keyset ON patients (last_name, first_name, patient_id);
+CREATE INDEX IF NOT EXISTS idx_patients_created_at_keyset ON patients ((coalesce(created_at, 'epoch')) DESC, patient_id DESC);
+CREATE INDEX IF NOT EXISTS idx_medical_records_patient_id ON medical_records (patient_id);
@@ -0,0 +1,129 @@
+CREATE TABLE sla_definitions
+(
+    severity        injury_severity PRIMARY KEY,
+    response_target INTERVAL NOT NULL,
+    updated_at      TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO sla_definitions (severity, response_target)
+VALUES ('CRITICAL', INTERVAL '7 minutes'),
+       ('HIGH', INTERVAL '18 minutes'),
+       ('MODERATE', INTERVAL '2 hours'),
+       ('LOW', INTERVAL '3 hours'),
+       ('UNKNOWN_INJURY_SEVERITY', INTERVAL '18 minutes');
+
+ALTER TABLE ambulance_requests
+    ADD COLUMN accepted_at  TIMESTAMP,
+    ADD COLUMN arrived_at   TIMESTAMP,
+    ADD COLUMN completed_at TIMESTAMP;
+
+-- the timings of a call from the moment it was made, taken from its earliest ambulance request. The response
+-- target is that of the call's severity when the row was last refreshed, so re-triaging a call moves its target.
+CREATE TABLE emergency_call_timings
+(
+    call_id            INT PRIMARY KEY REFERENCES emergency_calls (call_id) ON DELETE CASCADE,
+    severity           injury_severity,
+    response_target    INTERVAL,
+    call_time          TIMESTAMP,
+    request_created_at TIMESTAMP,
+    accepted_at        TIMESTAMP,
+    arrived_at         TIMESTAMP,
+    completed_at       TIMESTAMP,
+    response_time      INTERVAL,
+    breached           BOOLEAN,
+    updated_at         TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_emergency_call_timings_call_time ON emergency_call_timings (call_time);
+
+CREATE FUNCTION refresh_emergency_call_timings(p_call_id INT) RETURNS VOID AS
+$$
+BEGIN
+    INSERT INTO emergency_call_timings (call_id, severity, response_target, call_time, request_created_at,
+                                        accepted_at, arrived_at, completed_at, response_time, breached)
+    SELECT emergency_calls.call_id,
+           emergency_calls.severity,
+           sla_definitions.response_target,
+           emergency_calls.call_time,
+           requests.created_at,
+           requests.accepted_at,
+           requests.arrived_at,
+           requests.completed_at,
+           requests.arrived_at - emergency_calls.call_time,
+           requests.arrived_at - emergency_calls.call_time > sla_definitions.response_target
+    FROM emergency_calls
+             LEFT JOIN sla_definitions ON sla_definitions.severity = emergency_calls.severity
+             LEFT JOIN LATERAL (
+        SELECT min(created_at)   AS created_at,
+               min(accepted_at)  AS accepted_at,
+               min(arrived_at)   AS arrived_at,
+               max(completed_at) AS completed_at
+        FROM ambulance_requests
+        WHERE ambulance_requests.emergency_call_id = emergency_calls.call_id
+        ) requests ON true
+    WHERE emergency_calls.call_id = p_call_id
+    ON CONFLICT (call_id) DO UPDATE SET severity           = EXCLUDED.severity,
+                                        response_target    = EXCLUDED.response_target,
+                                        call_time          = EXCLUDED.call_time,
+                                        request_created_at = EXCLUDED.request_created_at,
+                                        accepted_at        = EXCLUDED.accepted_at,
+                                        arrived_at         = EXCLUDED.arrived_at,
+                                        completed_at       = EXCLUDED.completed_at,
+                                        response_time      = EXCLUDED.response_time,
+                                        breached           = EXCLUDED.breached,
+                                        updated_at         = CURRENT_TIMESTAMP;
+END;
+$$ LANGUAGE plpgsql;
+
+-- stamp requests as they move through their statuses, whichever code path moves them
+CREATE FUNCTION stamp_ambulance_request_status() RETURNS TRIGGER AS
+$$
+BEGIN
+    IF TG_OP = 'INSERT' OR NEW.status IS DISTINCT FROM OLD.status THEN
+        IF NEW.status = 'ACCEPTED' THEN
+            NEW.accepted_at = coalesce(NEW.accepted_at, CURRENT_TIMESTAMP);
+        ELSIF NEW.status = 'COMPLETED' THEN
+            NEW.completed_at = coalesce(NEW.completed_at, CURRENT_TIMESTAMP);
+        END IF;
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER ambulance_requests_status_timestamps
+    BEFORE INSERT OR UPDATE
+    ON ambulance_requests
+    FOR EACH ROW
+EXECUTE FUNCTION stamp_ambulance_request_status();
+
+CREATE FUNCTION refresh_request_call_timings() RETURNS TRIGGER AS
+$$
+BEGIN
+    PERFORM refresh_emergency_call_timings(NEW.emergency_call_id);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER ambulance_requests_call_timings
+    AFTER INSERT OR UPDATE OF status, created_at, accepted_at, arrived_at, completed_at
+    ON ambulance_requests
+    FOR EACH ROW
+EXECUTE FUNCTION refresh_request_call_timings();
+
+CREATE FUNCTION refresh_call_timings() RETURNS TRIGGER AS
+$$
+BEGIN
+    PERFORM refresh_emergency_call_timings(NEW.call_id);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER emergency_calls_call_timings
+    AFTER INSERT OR UPDATE OF call_time, severity
+    ON emergency_calls
+    FOR EACH ROW
+EXECUTE FUNCTION refresh_call_timings();
+
+SELECT refresh_emergency_call_timings(call_id)
+FROM emergency_calls;
@@ -0,0 +1,14 @@
+CREATE TABLE staff_shifts
+(
+    shift_id     SERIAL PRIMARY KEY,
+    staff_id     INT        NOT NULL REFERENCES ambulance_staff (staff_id) ON DELETE CASCADE,
+    ambulance_id INT        NOT NULL REFERENCES ambulances (ambulance_id) ON DELETE CASCADE,
+    role         staff_role NOT NULL,
+    starts_at    TIMESTAMP  NOT NULL,
+    ends_at      TIMESTAMP  NOT NULL,
+    created_at   TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (ends_at > starts_at)
+);
+
+CREATE INDEX idx_staff_shifts_staff_id ON staff_shifts (staff_id, starts_at);
+CREATE INDEX idx_staff_shifts_ambulance_id ON staff_shifts (ambulance_id, starts_at, ends_at);
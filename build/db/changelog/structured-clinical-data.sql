@@ -0,0 +1,61 @@
+CREATE TYPE allergy_severity AS ENUM ('UNKNOWN_ALLERGY_SEVERITY', 'MILD', 'MODERATE', 'SEVERE', 'LIFE_THREATENING');
+
+-- structured entries are the source of truth, the text arrays on medical_records are kept as their
+-- free text projection for the existing protobuf fields
+CREATE TABLE medical_conditions
+(
+    condition_id SERIAL PRIMARY KEY,
+    record_id    INT  NOT NULL REFERENCES medical_records (record_id) ON DELETE CASCADE,
+    snomed_code  VARCHAR(20), -- SNOMED CT concept id
+    description  TEXT NOT NULL,
+    onset_date   DATE,
+    recorded_at  TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE medical_medications
+(
+    medication_id SERIAL PRIMARY KEY,
+    record_id     INT  NOT NULL REFERENCES medical_records (record_id) ON DELETE CASCADE,
+    dmd_code      VARCHAR(20), -- dm+d concept id
+    name          TEXT NOT NULL,
+    dosage        TEXT,
+    start_date    DATE,
+    recorded_at   TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE medical_allergies
+(
+    allergy_id  SERIAL PRIMARY KEY,
+    record_id   INT  NOT NULL REFERENCES medical_records (record_id) ON DELETE CASCADE,
+    snomed_code VARCHAR(20), -- SNOMED CT concept id
+    substance   TEXT NOT NULL,
+    reaction    TEXT,
+    severity    allergy_severity DEFAULT 'UNKNOWN_ALLERGY_SEVERITY',
+    onset_date  DATE,
+    recorded_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_medical_conditions_record_id ON medical_conditions (record_id);
+CREATE INDEX idx_medical_medications_record_id ON medical_medications (record_id);
+CREATE INDEX idx_medical_allergies_record_id ON medical_allergies (record_id);
+CREATE INDEX idx_medical_allergies_substance ON medical_allergies (lower(substance));
+
+-- existing free text becomes uncoded entries
+INSERT INTO medical_conditions (record_id, description, recorded_at)
+SELECT DISTINCT ON (record_id, lower(trim(condition))) record_id, trim(condition), last_updated
+FROM medical_records, unnest(conditions) AS condition
+WHERE trim(condition) <> '';
+
+INSERT INTO medical_medications (record_id, name, recorded_at)
+SELECT DISTINCT ON (record_id, lower(trim(medication))) record_id, trim(medication), last_updated
+FROM medical_records, unnest(medications) AS medication
+WHERE trim(medication) <> '';
+
+INSERT INTO medical_allergies (record_id, substance, recorded_at)
+SELECT DISTINCT ON (record_id, lower(trim(allergy))) record_id, trim(allergy), last_updated
+FROM medical_records, unnest(allergies) AS allergy
+WHERE trim(allergy) <> '';
+
+-- merges snapshot the duplicate's coded entries so a revert can restore them, merges made before have none
+ALTER TABLE patient_merges
+    ADD COLUMN duplicate_entries JSONB NOT NULL DEFAULT '{}';
@@ -0,0 +1,34 @@
+-- ambulances can only be available with at least one active paramedic and driver on their crew. Ambulances that
+-- were available before crews were tracked are reported and taken out of service until they have been crewed, and new
+-- ambulances start out of service rather than available.
+ALTER TABLE ambulances ALTER COLUMN status SET DEFAULT 'UNKNOWN_AMBULANCE_STATUS';
+
+DO
+$$
+    DECLARE
+        uncrewed TEXT;
+    BEGIN
+        SELECT string_agg(ambulance_number || ' (' || ambulance_id || ')', ', ' ORDER BY ambulance_id)
+        INTO uncrewed
+        FROM ambulances
+        WHERE status = 'AVAILABLE'
+          AND (SELECT count(DISTINCT role)
+               FROM ambulance_staff
+               WHERE ambulance_staff.ambulance_id = ambulances.ambulance_id
+                 AND ambulance_staff.is_active
+                 AND role IN ('PARAMEDIC', 'DRIVER')) < 2;
+
+        IF uncrewed IS NOT NULL THEN
+            RAISE NOTICE 'taking available ambulances without a paramedic and a driver out of service: %', uncrewed;
+
+            UPDATE ambulances
+            SET status = 'UNKNOWN_AMBULANCE_STATUS'
+            WHERE status = 'AVAILABLE'
+              AND (SELECT count(DISTINCT role)
+                   FROM ambulance_staff
+                   WHERE ambulance_staff.ambulance_id = ambulances.ambulance_id
+                     AND ambulance_staff.is_active
+                     AND role IN ('PARAMEDIC', 'DRIVER')) < 2;
+        END IF;
+    END
+$$;
@@ -0,0 +1,146 @@
+// enumgen generates the strict mappings between the schema's enum types and their protobuf and postgres
+// counterparts. The protobuf enum values are expected to be named <Type>_<VALUE> and the postgres enum types
+// to be the snake case of the Go type, with the Go constants holding the labels of both.
+//
+//	enumgen -input enums.go -output enums_pb.go InjurySeverity RequestStatus ...
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type enumConst struct {
+	name  string
+	value string
+}
+
+func main() {
+	input := flag.String("input", "enums.go", "file declaring the enum types")
+	output := flag.String("output", "enums_pb.go", "file to write")
+	flag.Parse()
+
+	types := flag.Args()
+	if len(types) == 0 {
+		log.Fatal("enumgen: no enum types given")
+	}
+
+	file, err := parser.ParseFile(token.NewFileSet(), *input, nil, 0)
+	if err != nil {
+		log.Fatalf("enumgen: %v", err)
+	}
+	consts := collectConsts(file)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by enumgen from %s; DO NOT EDIT.\n\n", *input)
+	fmt.Fprintf(&buf, "package %s\n\n", file.Name.Name)
+	buf.WriteString("import (\n\t\"fmt\"\n\tpbSchema \"github.com/jamieyoung5/kwikmedical-eventstream/pb\"\n)\n\n")
+
+	buf.WriteString("// DatabaseEnumLabels are the labels each postgres enum type is expected to have, in order\n")
+	buf.WriteString("var DatabaseEnumLabels = map[string][]string{\n")
+	for _, typeName := range types {
+		fmt.Fprintf(&buf, "\t%q: {", snakeCase(typeName))
+		for i, c := range consts[typeName] {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(strconv.Quote(c.value))
+		}
+		buf.WriteString("},\n")
+	}
+	buf.WriteString("}\n")
+
+	for _, typeName := range types {
+		values := consts[typeName]
+		if len(values) == 0 {
+			log.Fatalf("enumgen: no constants found for %s", typeName)
+		}
+		writeEnum(&buf, typeName, values)
+	}
+
+	source, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatalf("enumgen: generated invalid code: %v", err)
+	}
+	if err = os.WriteFile(*output, source, 0644); err != nil {
+		log.Fatalf("enumgen: %v", err)
+	}
+}
+
+// collectConsts finds the typed string constants of every type, in declaration order
+func collectConsts(file *ast.File) map[string][]enumConst {
+	consts := map[string][]enumConst{}
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			value := spec.(*ast.ValueSpec)
+			ident, ok := value.Type.(*ast.Ident)
+			if !ok || len(value.Values) != len(value.Names) {
+				continue
+			}
+			for i, name := range value.Names {
+				literal, ok := value.Values[i].(*ast.BasicLit)
+				if !ok || literal.Kind != token.STRING {
+					continue
+				}
+				label, err := strconv.Unquote(literal.Value)
+				if err != nil {
+					log.Fatalf("enumgen: %v", err)
+				}
+				consts[ident.Name] = append(consts[ident.Name], enumConst{name: name.Name, value: label})
+			}
+		}
+	}
+	return consts
+}
+
+func writeEnum(buf *bytes.Buffer, typeName string, values []enumConst) {
+	fmt.Fprintf(buf, "\n// %sValues are every %s, in declaration order\n", typeName, typeName)
+	fmt.Fprintf(buf, "var %sValues = []%s{", typeName, typeName)
+	for i, c := range values {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(c.name)
+	}
+	buf.WriteString("}\n")
+
+	fmt.Fprintf(buf, "\nfunc %sFromPb(value pbSchema.%s) (%s, error) {\n\tswitch value {\n", typeName, typeName, typeName)
+	for _, c := range values {
+		fmt.Fprintf(buf, "\tcase pbSchema.%s_%s:\n\t\treturn %s, nil\n", typeName, c.value, c.name)
+	}
+	fmt.Fprintf(buf, "\tdefault:\n\t\treturn \"\", fmt.Errorf(\"%%w: %%d is not a valid %s\", ErrUnknownEnumValue, value)\n\t}\n}\n", typeName)
+
+	fmt.Fprintf(buf, "\nfunc (v %s) ToPb() (pbSchema.%s, error) {\n\tswitch v {\n", typeName, typeName)
+	for _, c := range values {
+		fmt.Fprintf(buf, "\tcase %s:\n\t\treturn pbSchema.%s_%s, nil\n", c.name, typeName, c.value)
+	}
+	fmt.Fprintf(buf, "\tdefault:\n\t\treturn 0, fmt.Errorf(\"%%w: %%q is not a valid %s\", ErrUnknownEnumValue, string(v))\n\t}\n}\n", typeName)
+}
+
+func snakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
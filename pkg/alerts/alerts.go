@@ -0,0 +1,110 @@
+package alerts
+
+import (
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/schema"
+	"sort"
+)
+
+type Alert struct {
+	RuleID   string   `json:"rule_id"`
+	Title    string   `json:"title"`
+	Message  string   `json:"message"`
+	Priority Priority `json:"priority"`
+	// Matches are the record entries that triggered the alert
+	Matches []string `json:"matches"`
+}
+
+// Evaluate runs the rule set against a medical record and its structured entries, either of which may be nil,
+// returning alerts ordered by priority and then by the order of the rules.
+func (rs RuleSet) Evaluate(record *schema.MedicalRecord, entries *schema.ClinicalEntries) []Alert {
+	if entries == nil {
+		entries = &schema.ClinicalEntries{}
+	}
+
+	fields := map[Field][]string{}
+	if record != nil {
+		fields[Conditions] = record.Conditions
+		fields[Medications] = record.Medications
+		fields[Allergies] = record.Allergies
+		fields[Notes] = record.Notes
+	}
+
+	// structured entries are mirrored into the record's text fields, so only their codes need collecting
+	codes := map[Field][]codedEntry{}
+	for _, condition := range entries.Conditions {
+		codes[Conditions] = append(codes[Conditions], codedEntry{condition.SnomedCode, condition.Display()})
+	}
+	for _, medication := range entries.Medications {
+		codes[Medications] = append(codes[Medications], codedEntry{medication.DmdCode, medication.Display()})
+	}
+	for _, allergy := range entries.Allergies {
+		codes[Allergies] = append(codes[Allergies], codedEntry{allergy.SnomedCode, allergy.Display()})
+	}
+
+	var alerts []Alert
+	for _, rule := range rs {
+		matches := rule.matches(fields, codes, entries.Allergies)
+		if len(matches) == 0 {
+			continue
+		}
+
+		alerts = append(alerts, Alert{
+			RuleID:   rule.ID,
+			Title:    rule.Title,
+			Message:  rule.Message,
+			Priority: rule.Priority,
+			Matches:  matches,
+		})
+	}
+
+	sort.SliceStable(alerts, func(i, j int) bool {
+		return priorityRanks[alerts[i].Priority] < priorityRanks[alerts[j].Priority]
+	})
+
+	return alerts
+}
+
+type codedEntry struct {
+	code    string
+	display string
+}
+
+func (r *Rule) matches(fields map[Field][]string, codes map[Field][]codedEntry, allergies []schema.MedicalAllergy) []string {
+	seen := map[string]struct{}{}
+	var matches []string
+	add := func(match string) {
+		if _, ok := seen[match]; !ok {
+			seen[match] = struct{}{}
+			matches = append(matches, match)
+		}
+	}
+
+	for _, field := range r.Fields {
+		for _, value := range fields[field] {
+			for _, pattern := range r.patterns {
+				if pattern.MatchString(value) {
+					add(value)
+					break
+				}
+			}
+		}
+
+		for _, entry := range codes[field] {
+			for _, code := range r.Codes {
+				if entry.code != "" && entry.code == code {
+					add(entry.display)
+				}
+			}
+		}
+	}
+
+	if r.MinAllergySeverity != "" {
+		for _, allergy := range allergies {
+			if allergySeverityRanks[allergy.Severity] >= allergySeverityRanks[r.MinAllergySeverity] {
+				add(allergy.Display())
+			}
+		}
+	}
+
+	return matches
+}
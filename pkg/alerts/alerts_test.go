@@ -0,0 +1,173 @@
+package alerts
+
+import (
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/schema"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	rules := RuleSet{
+		{
+			ID:       "anticoagulants",
+			Priority: High,
+			Fields:   []Field{Medications},
+			Keywords: []string{"warfarin"},
+		},
+		{
+			ID:       "diabetes",
+			Priority: Medium,
+			Fields:   []Field{Conditions},
+			Keywords: []string{"diabet"},
+			Codes:    []string{"73211009"},
+		},
+		{
+			ID:                 "anaphylaxis",
+			Priority:           Critical,
+			Fields:             []Field{Allergies},
+			Keywords:           []string{"anaphyla"},
+			MinAllergySeverity: schema.AllergyLifeThreatening,
+		},
+	}
+	if err := rules.Compile(); err != nil {
+		t.Fatalf("Compile() returned error %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		record  *schema.MedicalRecord
+		entries *schema.ClinicalEntries
+		want    map[string][]string
+	}{
+		{
+			name: "nothing recorded",
+			want: map[string][]string{},
+		},
+		{
+			name:   "keyword in text",
+			record: &schema.MedicalRecord{Medications: []string{"Warfarin 5mg"}},
+			want:   map[string][]string{"anticoagulants": {"Warfarin 5mg"}},
+		},
+		{
+			name:   "keyword at the start of a word only",
+			record: &schema.MedicalRecord{Conditions: []string{"prediabetes"}},
+			want:   map[string][]string{},
+		},
+		{
+			name:   "keyword in a field the rule does not check",
+			record: &schema.MedicalRecord{Notes: []string{"warfarin stopped"}},
+			want:   map[string][]string{},
+		},
+		{
+			name: "code",
+			entries: &schema.ClinicalEntries{
+				Conditions: []schema.MedicalCondition{{SnomedCode: "73211009", Description: "DM"}},
+			},
+			want: map[string][]string{"diabetes": {"DM"}},
+		},
+		{
+			name: "severity at the threshold",
+			entries: &schema.ClinicalEntries{
+				Allergies: []schema.MedicalAllergy{{Substance: "Peanut", Severity: schema.AllergyLifeThreatening}},
+			},
+			want: map[string][]string{"anaphylaxis": {"Peanut (" + string(schema.AllergyLifeThreatening) + ")"}},
+		},
+		{
+			name: "severity below the threshold",
+			entries: &schema.ClinicalEntries{
+				Allergies: []schema.MedicalAllergy{{Substance: "Peanut", Severity: schema.AllergySevere}},
+			},
+			want: map[string][]string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := map[string][]string{}
+			for _, alert := range rules.Evaluate(test.record, test.entries) {
+				got[alert.RuleID] = alert.Matches
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Evaluate() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateOrdersByPriority(t *testing.T) {
+	record := &schema.MedicalRecord{
+		Conditions:  []string{"Diabetes type 2", "Epilepsy"},
+		Medications: []string{"Warfarin"},
+		Allergies:   []string{"Anaphylaxis to peanuts"},
+	}
+
+	var got []string
+	for _, alert := range DefaultRules().Evaluate(record, nil) {
+		got = append(got, alert.RuleID)
+	}
+
+	want := []string{"anaphylaxis", "anticoagulants", "diabetes", "epilepsy"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Evaluate() = %v, want %v", got, want)
+	}
+}
+
+func TestCompileInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+	}{
+		{name: "no id", rule: Rule{Priority: High}},
+		{name: "unknown priority", rule: Rule{ID: "rule", Priority: "URGENT"}},
+		{name: "unknown allergy severity", rule: Rule{ID: "rule", Priority: High, MinAllergySeverity: "FATAL"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := (RuleSet{&test.rule}).Compile(); err == nil {
+				t.Errorf("Compile() of %+v returned no error", test.rule)
+			}
+		})
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, contents string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	rules, err := LoadRules(write("rules.json",
+		`[{"id": "sepsis", "priority": "HIGH", "fields": ["conditions"], "keywords": ["sepsis"]}]`))
+	if err != nil {
+		t.Fatalf("LoadRules() returned error %v", err)
+	}
+	alerts := rules.Evaluate(&schema.MedicalRecord{Conditions: []string{"Sepsis"}}, nil)
+	if len(alerts) != 1 || alerts[0].RuleID != "sepsis" {
+		t.Errorf("loaded rules raised %v, want the sepsis alert", alerts)
+	}
+
+	invalid := []struct {
+		name string
+		path string
+	}{
+		{name: "missing file", path: filepath.Join(dir, "missing.json")},
+		{name: "not json", path: write("not-json.json", `sepsis`)},
+		{name: "not an array", path: write("object.json", `{"id": "sepsis", "priority": "HIGH"}`)},
+		{name: "invalid rule", path: write("invalid-rule.json", `[{"id": "sepsis", "priority": "URGENT"}]`)},
+	}
+
+	for _, test := range invalid {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := LoadRules(test.path); err == nil {
+				t.Errorf("LoadRules(%q) returned no error", test.path)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package alerts
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/schema"
+	"os"
+	"regexp"
+	"strings"
+)
+
+type Priority string
+
+const (
+	Critical Priority = "CRITICAL"
+	High     Priority = "HIGH"
+	Medium   Priority = "MEDIUM"
+	Low      Priority = "LOW"
+)
+
+var priorityRanks = map[Priority]int{
+	Critical: 0,
+	High:     1,
+	Medium:   2,
+	Low:      3,
+}
+
+type Field string
+
+const (
+	Conditions  Field = "conditions"
+	Medications Field = "medications"
+	Allergies   Field = "allergies"
+	Notes       Field = "notes"
+)
+
+var allergySeverityRanks = map[schema.AllergySeverity]int{
+	schema.UnknownAllergySeverity: 0,
+	schema.AllergyMild:            1,
+	schema.AllergyModerate:        2,
+	schema.AllergySevere:          3,
+	schema.AllergyLifeThreatening: 4,
+}
+
+// Rule raises an alert when any of its keywords or codes appear in the given fields of a medical record, or when
+// the patient has an allergy at or above MinAllergySeverity. Keywords match case-insensitively at the start of a
+// word, so "anaphyla" matches both "anaphylaxis" and "anaphylactic".
+type Rule struct {
+	ID                 string                 `json:"id"`
+	Title              string                 `json:"title"`
+	Message            string                 `json:"message"`
+	Priority           Priority               `json:"priority"`
+	Fields             []Field                `json:"fields"`
+	Keywords           []string               `json:"keywords"`
+	Codes              []string               `json:"codes"`
+	MinAllergySeverity schema.AllergySeverity `json:"min_allergy_severity"`
+
+	patterns []*regexp.Regexp
+}
+
+type RuleSet []*Rule
+
+// DefaultRules are used when no rule file has been configured.
+func DefaultRules() RuleSet {
+	rules := RuleSet{
+		{
+			ID:                 "anaphylaxis",
+			Title:              "Anaphylaxis history",
+			Message:            "Patient has a history of anaphylaxis or a life threatening allergy, carry adrenaline.",
+			Priority:           Critical,
+			Fields:             []Field{Allergies, Conditions, Notes},
+			Keywords:           []string{"anaphyla"},
+			Codes:              []string{"39579001"},
+			MinAllergySeverity: schema.AllergyLifeThreatening,
+		},
+		{
+			ID:       "dnr",
+			Title:    "Do not resuscitate",
+			Message:  "A DNACPR decision is recorded, confirm the documentation on scene.",
+			Priority: Critical,
+			Fields:   []Field{Notes, Conditions},
+			Keywords: []string{"dnr", "dnacpr", "do not resuscitate", "do not attempt resuscitation"},
+			Codes:    []string{"304253006"},
+		},
+		{
+			ID:       "anticoagulants",
+			Title:    "Anticoagulant use",
+			Message:  "Patient is on anticoagulants, expect increased bleeding risk.",
+			Priority: High,
+			Fields:   []Field{Medications},
+			Keywords: []string{"warfarin", "apixaban", "rivaroxaban", "edoxaban", "dabigatran", "heparin", "enoxaparin", "dalteparin", "tinzaparin"},
+		},
+		{
+			ID:                 "severe-allergy",
+			Title:              "Severe allergy",
+			Message:            "Patient has a severe allergy, check before administering any drugs.",
+			Priority:           High,
+			MinAllergySeverity: schema.AllergySevere,
+		},
+		{
+			ID:       "penicillin-allergy",
+			Title:    "Penicillin allergy",
+			Message:  "Patient is allergic to penicillin, avoid penicillin based antibiotics.",
+			Priority: High,
+			Fields:   []Field{Allergies},
+			Keywords: []string{"penicillin", "amoxicillin", "flucloxacillin"},
+			Codes:    []string{"91936005"},
+		},
+		{
+			ID:       "diabetes",
+			Title:    "Diabetes",
+			Message:  "Patient is diabetic, check blood glucose.",
+			Priority: Medium,
+			Fields:   []Field{Conditions, Medications},
+			Keywords: []string{"diabet", "insulin", "metformin"},
+			Codes:    []string{"73211009"},
+		},
+		{
+			ID:       "epilepsy",
+			Title:    "Epilepsy",
+			Message:  "Patient has epilepsy or a history of seizures.",
+			Priority: Medium,
+			Fields:   []Field{Conditions},
+			Keywords: []string{"epilep", "seizure"},
+			Codes:    []string{"84757009"},
+		},
+	}
+
+	if err := rules.Compile(); err != nil {
+		panic(fmt.Sprintf("invalid default alert rule: %v", err))
+	}
+
+	return rules
+}
+
+// LoadRules reads a JSON array of rules from the given file, allowing services to configure alerts locally.
+func LoadRules(path string) (RuleSet, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read alert rules: %w", err)
+	}
+
+	var rules RuleSet
+	if err = json.Unmarshal(bytes, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse alert rules: %w", err)
+	}
+
+	if err = rules.Compile(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// Compile validates the rules and prepares their keyword patterns. Rule sets built by hand rather than loaded
+// with LoadRules must be compiled before use, otherwise their keywords never match.
+func (rs RuleSet) Compile() error {
+	for _, rule := range rs {
+		if rule.ID == "" {
+			return fmt.Errorf("alert rule %q has no id", rule.Title)
+		}
+		if _, ok := priorityRanks[rule.Priority]; !ok {
+			return fmt.Errorf("alert rule %q has unknown priority %q", rule.ID, rule.Priority)
+		}
+		if _, ok := allergySeverityRanks[rule.MinAllergySeverity]; rule.MinAllergySeverity != "" && !ok {
+			return fmt.Errorf("alert rule %q has unknown allergy severity %q", rule.ID, rule.MinAllergySeverity)
+		}
+
+		rule.patterns = make([]*regexp.Regexp, len(rule.Keywords))
+		for i, keyword := range rule.Keywords {
+			pattern, err := regexp.Compile(`(?i)\b` + regexp.QuoteMeta(strings.TrimSpace(keyword)))
+			if err != nil {
+				return fmt.Errorf("alert rule %q has invalid keyword %q: %w", rule.ID, keyword, err)
+			}
+			rule.patterns[i] = pattern
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,197 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/schema"
+	"github.com/jamieyoung5/kwikmedical-eventstream/pb"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+)
+
+type AmbulanceFilter struct {
+	Statuses   []pb.AmbulanceStatus
+	HospitalID uint
+	Limit      int
+}
+
+// CreateAmbulance adds an ambulance to the fleet. New ambulances have no crew so cannot be created available,
+// unless another status is given they are left unknown until a crew is assigned and they are set available.
+func (db *KwikMedicalDBClient) CreateAmbulance(ambulance *pb.Ambulance) (*pb.Ambulance, error) {
+	newAmbulance, err := schema.AmbulancePbToGorm(ambulance)
+	if err != nil {
+		return nil, err
+	}
+
+	newAmbulance.AmbulanceID = 0
+	newAmbulance.AmbulanceNumber = strings.TrimSpace(newAmbulance.AmbulanceNumber)
+	if newAmbulance.AmbulanceNumber == "" {
+		return nil, errors.New("an ambulance number is required")
+	}
+	if newAmbulance.Status == schema.Available {
+		return nil, fmt.Errorf("%w: a new ambulance has no crew", ErrIncompleteCrew)
+	}
+
+	err = db.DbTransaction(func(tx *gorm.DB) error {
+		if newAmbulance.RegionalHospitalID != nil {
+			if err := hospitalExists(tx, *newAmbulance.RegionalHospitalID); err != nil {
+				return err
+			}
+		}
+		return tx.Create(&newAmbulance).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newAmbulance.ToPb()
+}
+
+func (db *KwikMedicalDBClient) GetAmbulance(ambulanceId uint) (*pb.Ambulance, error) {
+	ambulance, err := getAmbulance(db.gormDb, ambulanceId)
+	if err != nil {
+		return nil, err
+	}
+
+	return ambulance.ToPb()
+}
+
+func (db *KwikMedicalDBClient) ListAmbulances(filter AmbulanceFilter) ([]*pb.Ambulance, error) {
+	query := db.gormDb.Table("ambulances")
+
+	if len(filter.Statuses) > 0 {
+		statuses := make([]schema.AmbulanceStatus, len(filter.Statuses))
+		for i, status := range filter.Statuses {
+			var err error
+			if statuses[i], err = schema.AmbulanceStatusFromPb(status); err != nil {
+				return nil, err
+			}
+		}
+		query = query.Where("status IN ?", statuses)
+	}
+	if filter.HospitalID != 0 {
+		query = query.Where("regional_hospital_id = ?", filter.HospitalID)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var ambulances []schema.Ambulance
+	if err := query.Order("ambulance_number ASC").Find(&ambulances).Error; err != nil {
+		return nil, err
+	}
+
+	results := make([]*pb.Ambulance, len(ambulances))
+	for i := range ambulances {
+		var err error
+		if results[i], err = ambulances[i].ToPb(); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+func (db *KwikMedicalDBClient) UpdateAmbulanceLocation(ambulanceId uint, location *pb.Location) (*pb.Ambulance, error) {
+	if location == nil {
+		return nil, errors.New("location is nil")
+	}
+
+	return db.updateAmbulance(ambulanceId, func(tx *gorm.DB, ambulance *schema.Ambulance) error {
+		return tx.Table("ambulances").
+			Where("ambulance_id = ?", ambulanceId).
+			Update("current_location", schema.LocationFromPb(location)).Error
+	})
+}
+
+func (db *KwikMedicalDBClient) SetAmbulanceStatus(ambulanceId uint, status pb.AmbulanceStatus) (*pb.Ambulance, error) {
+	if status == pb.AmbulanceStatus_UNKNOWN_AMBULANCE_STATUS {
+		return nil, errors.New("cannot set an ambulance to an unknown status")
+	}
+	ambulanceStatus, err := schema.AmbulanceStatusFromPb(status)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.updateAmbulance(ambulanceId, func(tx *gorm.DB, ambulance *schema.Ambulance) error {
+		if ambulanceStatus == schema.Available {
+			if err := requireCrew(tx, ambulanceId); err != nil {
+				return err
+			}
+		}
+
+		return tx.Table("ambulances").
+			Where("ambulance_id = ?", ambulanceId).
+			Update("status", ambulanceStatus).Error
+	})
+}
+
+// TransferAmbulance moves an ambulance to another regional hospital. Ambulances on a call cannot be transferred
+// until they have completed it.
+func (db *KwikMedicalDBClient) TransferAmbulance(ambulanceId uint, toHospital uint) (*pb.Ambulance, error) {
+	return db.updateAmbulance(ambulanceId, func(tx *gorm.DB, ambulance *schema.Ambulance) error {
+		if ambulance.Status == schema.OnCall {
+			return fmt.Errorf("ambulance %d is on a call and cannot be transferred", ambulanceId)
+		}
+		if err := hospitalExists(tx, toHospital); err != nil {
+			return err
+		}
+
+		return tx.Table("ambulances").
+			Where("ambulance_id = ?", ambulanceId).
+			Update("regional_hospital_id", toHospital).Error
+	})
+}
+
+// updateAmbulance locks the ambulance for the update and returns it as it is afterwards
+func (db *KwikMedicalDBClient) updateAmbulance(ambulanceId uint, update func(tx *gorm.DB, ambulance *schema.Ambulance) error) (*pb.Ambulance, error) {
+	var ambulance *schema.Ambulance
+	err := db.DbTransaction(func(tx *gorm.DB) error {
+		current, err := getAmbulance(tx.Clauses(clause.Locking{Strength: "UPDATE"}), ambulanceId)
+		if err != nil {
+			return err
+		}
+		if err = update(tx, current); err != nil {
+			return err
+		}
+
+		ambulance, err = getAmbulance(tx, ambulanceId)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ambulance.ToPb()
+}
+
+func getAmbulance(tx *gorm.DB, ambulanceId uint) (*schema.Ambulance, error) {
+	var ambulance schema.Ambulance
+
+	err := tx.Table("ambulances").
+		Where("ambulance_id = ?", ambulanceId).
+		Take(&ambulance).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("no ambulance found with id %d", ambulanceId)
+		}
+		return nil, err
+	}
+
+	return &ambulance, nil
+}
+
+func hospitalExists(tx *gorm.DB, hospitalId uint) error {
+	var count int64
+	err := tx.Table("regional_hospitals").
+		Where("hospital_id = ?", hospitalId).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("no hospital found with id %d", hospitalId)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/schema"
+	"github.com/jamieyoung5/kwikmedical-eventstream/pb"
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	DuplicateCallsIgnore = "ignore"
+	DuplicateCallsLink   = "link"
+	DuplicateCallsReturn = "return"
+
+	MatchedCallerPhone      = "caller_phone"
+	MatchedLocation         = "location"
+	MatchedMedicalCondition = "medical_condition"
+
+	maxDuplicateCalls = 10
+)
+
+var ErrDuplicateCall = errors.New("emergency call is a probable duplicate")
+
+// DuplicateCallsError is returned instead of inserting a call when duplicate calls are handed back to the
+// call handler. If the handler decides the call is about a different event it can be inserted with
+// InsertDistinctEmergencyCall.
+type DuplicateCallsError struct {
+	Candidates []DuplicateCallCandidate
+}
+
+func (e *DuplicateCallsError) Error() string {
+	return fmt.Sprintf("%s of %d existing calls", ErrDuplicateCall, len(e.Candidates))
+}
+
+func (e *DuplicateCallsError) Unwrap() error {
+	return ErrDuplicateCall
+}
+
+type DuplicateCallCandidate struct {
+	Call *pb.EmergencyCall
+	// DistanceMetres is nil when either call has no location
+	DistanceMetres      *float64
+	ConditionSimilarity float64
+	MatchedFields       []string
+}
+
+type duplicateCallRow struct {
+	schema.EmergencyCall
+	SamePhone           bool
+	Distance            *float64
+	ConditionSimilarity float64
+}
+
+// FindDuplicateCalls finds recent calls that are probably about the same event as the given call: those made
+// from the same phone, or made nearby about a similar medical condition, within the configured time window.
+// Calls whose ambulance has already completed are not considered. Candidates are returned best first.
+func (db *KwikMedicalDBClient) FindDuplicateCalls(call *pb.EmergencyCall) ([]DuplicateCallCandidate, error) {
+	return findDuplicateCalls(db.gormDb, db.config.CallDuplicateWindow, db.config.CallDuplicateRadius,
+		db.config.CallDuplicateSimilarity, call)
+}
+
+func findDuplicateCalls(tx *gorm.DB, window time.Duration, radius float64, similarity float64, call *pb.EmergencyCall) ([]DuplicateCallCandidate, error) {
+	callTime := time.Now()
+	if call.CallTime != nil {
+		callTime = call.CallTime.AsTime()
+	}
+
+	args := map[string]interface{}{
+		"call_id":    call.CallId,
+		"phone":      normalizePhoneNumber(call.CallerPhone),
+		"condition":  call.MedicalCondition,
+		"from":       callTime.Add(-window),
+		"to":         callTime.Add(window),
+		"radius":     radius,
+		"similarity": similarity,
+		"completed":  schema.Completed,
+		"limit":      maxDuplicateCalls,
+	}
+
+	distance := "NULL"
+	if call.Location != nil && (call.Location.Latitude != 0 || call.Location.Longitude != 0) {
+		location, err := schema.LocationFromPb(call.Location).Value()
+		if err != nil {
+			return nil, err
+		}
+		args["location"] = location
+		distance = "location_distance(CAST(NULLIF(location, '') AS jsonb), CAST(@location AS jsonb))"
+	}
+
+	query := `
+	SELECT * FROM (
+		SELECT emergency_calls.*,
+			@phone <> '' AND right(regexp_replace(coalesce(caller_phone, ''), '\D', '', 'g'), 10) = @phone AS same_phone,
+			` + distance + ` AS distance,
+			similarity(lower(coalesce(medical_condition, '')), lower(@condition)) AS condition_similarity
+		FROM emergency_calls
+		WHERE call_time BETWEEN @from AND @to
+			AND call_id <> @call_id
+			AND status <> @completed
+	) scored
+	WHERE same_phone OR (distance <= @radius AND condition_similarity >= @similarity)
+	ORDER BY same_phone DESC, distance ASC NULLS LAST, condition_similarity DESC
+	LIMIT @limit
+`
+
+	var rows []duplicateCallRow
+	if err := tx.Raw(query, args).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	candidates := make([]DuplicateCallCandidate, len(rows))
+	for i, row := range rows {
+		var matchedFields []string
+		if row.SamePhone {
+			matchedFields = append(matchedFields, MatchedCallerPhone)
+		}
+		if row.Distance != nil && *row.Distance <= radius {
+			matchedFields = append(matchedFields, MatchedLocation)
+		}
+		if row.ConditionSimilarity >= similarity {
+			matchedFields = append(matchedFields, MatchedMedicalCondition)
+		}
+
+		call, err := row.EmergencyCall.ToPb()
+		if err != nil {
+			return nil, err
+		}
+
+		candidates[i] = DuplicateCallCandidate{
+			Call:                call,
+			DistanceMetres:      row.Distance,
+			ConditionSimilarity: row.ConditionSimilarity,
+			MatchedFields:       matchedFields,
+		}
+	}
+
+	return candidates, nil
+}
+
+// InsertDistinctEmergencyCall inserts a call without checking for duplicates, for when the call handler has
+// confirmed the call is about a different event to the candidates they were given.
+func (db *KwikMedicalDBClient) InsertDistinctEmergencyCall(call *pb.EmergencyCall) (int32, error) {
+	emergencyCall, err := schema.EmergencyCallPbToGorm(call)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := db.gormDb.Create(&emergencyCall).Error; err != nil {
+		return 0, err
+	}
+
+	return int32(emergencyCall.CallID), nil
+}
+
+// LinkDuplicateCall marks a call as being about the same event as an earlier call. Calls are always linked to
+// the first call about the event, so linking to a duplicate links to its parent instead. Linked calls join the
+// first call's incident if it has one.
+func (db *KwikMedicalDBClient) LinkDuplicateCall(callId uint, parentCallId uint) error {
+	return db.DbTransaction(func(tx *gorm.DB) error {
+		rootId, err := rootCallID(tx, parentCallId)
+		if err != nil {
+			return err
+		}
+		if rootId == callId {
+			return errors.New("an emergency call cannot be a duplicate of itself")
+		}
+
+		linked := map[string]interface{}{
+			"parent_call_id": rootId,
+			"incident_id": gorm.Expr("coalesce((SELECT incident_id FROM emergency_calls WHERE call_id = ?), incident_id)",
+				rootId),
+		}
+
+		result := tx.Table("emergency_calls").
+			Where("call_id = ?", callId).
+			Updates(linked)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("no emergency call found with id %d", callId)
+		}
+
+		// calls that were linked to this call now belong to the same event
+		return tx.Table("emergency_calls").
+			Where("parent_call_id = ?", callId).
+			Updates(linked).Error
+	})
+}
+
+func callIncidentID(tx *gorm.DB, callId uint) (*uint, error) {
+	var call schema.EmergencyCall
+	if err := tx.Select("call_id", "incident_id").First(&call, callId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("no emergency call found with id %d", callId)
+		}
+		return nil, err
+	}
+
+	return call.IncidentID, nil
+}
+
+func rootCallID(tx *gorm.DB, callId uint) (uint, error) {
+	var call schema.EmergencyCall
+	if err := tx.Select("call_id", "parent_call_id").First(&call, callId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, fmt.Errorf("no emergency call found with id %d", callId)
+		}
+		return 0, err
+	}
+
+	if call.ParentCallID != nil {
+		return *call.ParentCallID, nil
+	}
+	return call.CallID, nil
+}
@@ -2,6 +2,8 @@ package client
 
 import (
 	"database/sql"
+	"fmt"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/alerts"
 	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/config"
 	_ "github.com/lib/pq"
 	"go.uber.org/zap"
@@ -22,6 +24,8 @@ type KwikMedicalDBClient struct {
 	logger      *zap.Logger
 	gormDb      *gorm.DB
 	sqlDb       SqlDb
+	config      *config.Config
+	alertRules  alerts.RuleSet
 	isConnected bool
 }
 
@@ -34,9 +38,11 @@ func NewKwikMedicalDBClient(logger *zap.Logger, gormDb *gorm.DB) (*KwikMedicalDB
 	}
 
 	return &KwikMedicalDBClient{
-		logger: logger,
-		gormDb: gormDb,
-		sqlDb:  sqlDb,
+		logger:     logger,
+		gormDb:     gormDb,
+		sqlDb:      sqlDb,
+		config:     config.NewConfig(),
+		alertRules: alerts.DefaultRules(),
 	}, nil
 }
 
@@ -58,7 +64,44 @@ func NewClient(logger *zap.Logger, dbConfig *config.Config) (*KwikMedicalDBClien
 		return nil, err
 	}
 
-	return NewKwikMedicalDBClient(logger, gormDb)
+	client, err := NewKwikMedicalDBClient(logger, gormDb)
+	if err != nil {
+		return nil, err
+	}
+	client.config = dbConfig
+
+	// a zero threshold falls back to the default, anything outside (0, 1] would match every or no candidate
+	if dbConfig.PatientMatchThreshold < 0 || dbConfig.PatientMatchThreshold > 1 {
+		return nil, fmt.Errorf("patient match threshold %v must be within (0, 1]", dbConfig.PatientMatchThreshold)
+	}
+	if dbConfig.PatientDuplicateThreshold < 0 || dbConfig.PatientDuplicateThreshold > 1 {
+		return nil, fmt.Errorf("patient duplicate threshold %v must be within (0, 1]", dbConfig.PatientDuplicateThreshold)
+	}
+
+	if err = client.CheckEnums(); err != nil {
+		logger.Error("Database enums do not match the schema", zap.Error(err))
+		return nil, err
+	}
+
+	if dbConfig.AlertRulesFile != "" {
+		client.alertRules, err = alerts.LoadRules(dbConfig.AlertRulesFile)
+		if err != nil {
+			logger.Error("Error loading alert rules", zap.String("file", dbConfig.AlertRulesFile), zap.Error(err))
+			return nil, err
+		}
+	}
+
+	return client, nil
+}
+
+// SetAlertRules compiles and replaces the rules used to raise alerts on patient retrieval, the current rules are
+// kept if any are invalid.
+func (db *KwikMedicalDBClient) SetAlertRules(rules alerts.RuleSet) error {
+	if err := rules.Compile(); err != nil {
+		return err
+	}
+	db.alertRules = rules
+	return nil
 }
 
 func (db *KwikMedicalDBClient) IsConnected() bool {
@@ -111,16 +154,16 @@ func (db *KwikMedicalDBClient) DbTransaction(fn func(tx *gorm.DB) error) error {
 	defer func() {
 		if r := recover(); r != nil {
 			tx.Rollback()
+			panic(r)
 		}
 	}()
 
 	err := fn(tx)
 	if err != nil {
 		db.logger.Error("Error executing transaction operation", zap.Error(err))
+		tx.Rollback()
 		return err
 	}
 
-	tx.Commit()
-
-	return nil
+	return tx.Commit().Error
 }
@@ -0,0 +1,274 @@
+package client
+
+import (
+	"fmt"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/schema"
+	"gorm.io/gorm"
+	"strings"
+)
+
+// GetClinicalEntries returns the structured conditions, medications and allergies of the patient's current medical record.
+func (db *KwikMedicalDBClient) GetClinicalEntries(patientId uint) (*schema.ClinicalEntries, error) {
+	var medicalRecord schema.MedicalRecord
+	err := db.gormDb.Where("patient_id = ?", patientId).
+		Order("last_updated DESC").
+		Limit(1).
+		Find(&medicalRecord).Error
+	if err != nil {
+		return nil, err
+	}
+	if medicalRecord.RecordID == 0 {
+		return &schema.ClinicalEntries{}, nil
+	}
+
+	return getClinicalEntriesByRecord(db.gormDb, medicalRecord.RecordID)
+}
+
+// AddConditionEntry adds a condition to the patient's medical record. An equivalent existing entry, with the same
+// SNOMED CT code or, when uncoded, the same description, is updated instead so repeated calls are idempotent.
+func (db *KwikMedicalDBClient) AddConditionEntry(patientId uint, condition schema.MedicalCondition, changedBy string) (*schema.MedicalCondition, error) {
+	if err := validateCondition(&condition); err != nil {
+		return nil, err
+	}
+
+	err := db.DbTransaction(func(tx *gorm.DB) error {
+		return addConditionEntry(tx, patientId, &condition, changedBy)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &condition, nil
+}
+
+func validateCondition(condition *schema.MedicalCondition) error {
+	if strings.TrimSpace(condition.Description) == "" {
+		return fmt.Errorf("cannot add a condition without a description")
+	}
+	return nil
+}
+
+func addConditionEntry(tx *gorm.DB, patientId uint, condition *schema.MedicalCondition, changedBy string) error {
+	recordId, err := beginMedicalRecordChange(tx, patientId, changedBy)
+	if err != nil {
+		return err
+	}
+
+	var existing []schema.MedicalCondition
+	if err = tx.Where("record_id = ?", recordId).Find(&existing).Error; err != nil {
+		return err
+	}
+
+	condition.RecordID = recordId
+	for _, entry := range existing {
+		if entry.Key() != condition.Key() {
+			continue
+		}
+
+		condition.ConditionID = entry.ConditionID
+		condition.RecordedAt = entry.RecordedAt
+		if err = removeFromRecordArray(tx, recordId, conditionsField, entry.Display()); err != nil {
+			return err
+		}
+	}
+
+	if err = tx.Save(condition).Error; err != nil {
+		return err
+	}
+
+	return appendToRecordArray(tx, recordId, conditionsField, condition.Display())
+}
+
+// AddMedicationEntry adds a medication to the patient's medical record. An equivalent existing entry, with the same
+// dm+d code or, when uncoded, the same name, is updated instead so repeated calls are idempotent.
+func (db *KwikMedicalDBClient) AddMedicationEntry(patientId uint, medication schema.MedicalMedication, changedBy string) (*schema.MedicalMedication, error) {
+	if err := validateMedication(&medication); err != nil {
+		return nil, err
+	}
+
+	err := db.DbTransaction(func(tx *gorm.DB) error {
+		return addMedicationEntry(tx, patientId, &medication, changedBy)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &medication, nil
+}
+
+func validateMedication(medication *schema.MedicalMedication) error {
+	if strings.TrimSpace(medication.Name) == "" {
+		return fmt.Errorf("cannot add a medication without a name")
+	}
+	return nil
+}
+
+func addMedicationEntry(tx *gorm.DB, patientId uint, medication *schema.MedicalMedication, changedBy string) error {
+	recordId, err := beginMedicalRecordChange(tx, patientId, changedBy)
+	if err != nil {
+		return err
+	}
+
+	var existing []schema.MedicalMedication
+	if err = tx.Where("record_id = ?", recordId).Find(&existing).Error; err != nil {
+		return err
+	}
+
+	medication.RecordID = recordId
+	for _, entry := range existing {
+		if entry.Key() != medication.Key() {
+			continue
+		}
+
+		medication.MedicationID = entry.MedicationID
+		medication.RecordedAt = entry.RecordedAt
+		if err = removeFromRecordArray(tx, recordId, medicationsField, entry.Display()); err != nil {
+			return err
+		}
+	}
+
+	if err = tx.Save(medication).Error; err != nil {
+		return err
+	}
+
+	return appendToRecordArray(tx, recordId, medicationsField, medication.Display())
+}
+
+// AddAllergyEntry adds an allergy to the patient's medical record. An equivalent existing entry, with the same
+// SNOMED CT code or, when uncoded, the same substance, is updated instead so repeated calls are idempotent.
+func (db *KwikMedicalDBClient) AddAllergyEntry(patientId uint, allergy schema.MedicalAllergy, changedBy string) (*schema.MedicalAllergy, error) {
+	if err := validateAllergy(&allergy); err != nil {
+		return nil, err
+	}
+
+	err := db.DbTransaction(func(tx *gorm.DB) error {
+		return addAllergyEntry(tx, patientId, &allergy, changedBy)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &allergy, nil
+}
+
+func validateAllergy(allergy *schema.MedicalAllergy) error {
+	if strings.TrimSpace(allergy.Substance) == "" {
+		return fmt.Errorf("cannot add an allergy without a substance")
+	}
+	if allergy.Severity == "" {
+		allergy.Severity = schema.UnknownAllergySeverity
+	}
+	return nil
+}
+
+func addAllergyEntry(tx *gorm.DB, patientId uint, allergy *schema.MedicalAllergy, changedBy string) error {
+	recordId, err := beginMedicalRecordChange(tx, patientId, changedBy)
+	if err != nil {
+		return err
+	}
+
+	var existing []schema.MedicalAllergy
+	if err = tx.Where("record_id = ?", recordId).Find(&existing).Error; err != nil {
+		return err
+	}
+
+	allergy.RecordID = recordId
+	for _, entry := range existing {
+		if entry.Key() != allergy.Key() {
+			continue
+		}
+
+		allergy.AllergyID = entry.AllergyID
+		allergy.RecordedAt = entry.RecordedAt
+		if err = removeFromRecordArray(tx, recordId, allergiesField, entry.Display()); err != nil {
+			return err
+		}
+	}
+
+	if err = tx.Save(allergy).Error; err != nil {
+		return err
+	}
+
+	return appendToRecordArray(tx, recordId, allergiesField, allergy.Display())
+}
+
+// removeClinicalEntry removes the entries matching the given text, or a code, from the patient's medical record
+// along with their free text projection. Removing something that is not recorded is a no-op.
+func (db *KwikMedicalDBClient) removeClinicalEntry(patientId uint, field medicalRecordField, value string, changedBy string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return fmt.Errorf("cannot remove an empty value from %s", field)
+	}
+
+	return db.DbTransaction(func(tx *gorm.DB) error {
+		recordId, err := beginMedicalRecordChange(tx, patientId, changedBy)
+		if err != nil {
+			return err
+		}
+
+		entries, err := getClinicalEntriesByRecord(tx, recordId)
+		if err != nil {
+			return err
+		}
+
+		key := strings.ToLower(value)
+		matches := func(code string, text string, display string) bool {
+			return code == value || strings.ToLower(strings.TrimSpace(text)) == key || strings.ToLower(display) == key
+		}
+
+		displays := []string{value}
+		switch field {
+		case conditionsField:
+			for _, entry := range entries.Conditions {
+				if matches(entry.SnomedCode, entry.Description, entry.Display()) {
+					displays = append(displays, entry.Display())
+					if err = tx.Delete(&entry).Error; err != nil {
+						return err
+					}
+				}
+			}
+		case medicationsField:
+			for _, entry := range entries.Medications {
+				if matches(entry.DmdCode, entry.Name, entry.Display()) {
+					displays = append(displays, entry.Display())
+					if err = tx.Delete(&entry).Error; err != nil {
+						return err
+					}
+				}
+			}
+		case allergiesField:
+			for _, entry := range entries.Allergies {
+				if matches(entry.SnomedCode, entry.Substance, entry.Display()) {
+					displays = append(displays, entry.Display())
+					if err = tx.Delete(&entry).Error; err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		for _, display := range displays {
+			if err = removeFromRecordArray(tx, recordId, field, display); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func getClinicalEntriesByRecord(tx *gorm.DB, recordIds ...uint) (*schema.ClinicalEntries, error) {
+	var entries schema.ClinicalEntries
+
+	if err := tx.Where("record_id IN ?", recordIds).Order("recorded_at ASC").Find(&entries.Conditions).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("record_id IN ?", recordIds).Order("recorded_at ASC").Find(&entries.Medications).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("record_id IN ?", recordIds).Order("recorded_at ASC").Find(&entries.Allergies).Error; err != nil {
+		return nil, err
+	}
+
+	return &entries, nil
+}
@@ -0,0 +1,219 @@
+package client
+
+import (
+	"errors"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/schema"
+	"github.com/jamieyoung5/kwikmedical-eventstream/pb"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strconv"
+	"time"
+)
+
+const (
+	// waitingSince is when the patient started waiting, the call time rather than when the request was raised
+	waitingSince = "coalesce(emergency_calls.call_time, ambulance_requests.created_at)"
+
+	// severityRank ranks the request's severity, falling back to the call's. Unknown severities are ranked
+	// as moderate so a call is never deprioritised just because the severity was not captured.
+	severityRank = `CASE coalesce(NULLIF(ambulance_requests.severity, 'UNKNOWN_INJURY_SEVERITY'), emergency_calls.severity)
+		WHEN 'CRITICAL' THEN 4
+		WHEN 'HIGH' THEN 3
+		WHEN 'LOW' THEN 1
+		ELSE 2
+	END`
+)
+
+// DispatchItem is a pending ambulance request in the dispatch queue
+type DispatchItem struct {
+	Request      *pb.AmbulanceRequest
+	WaitingSince time.Time
+	Priority     float64
+	ClaimedBy    string
+	ClaimedUntil *time.Time
+}
+
+type DispatchQueueDepth struct {
+	// HospitalID is 0 for requests that have not been routed to a hospital yet
+	HospitalID         uint
+	Depth              int
+	Claimed            int
+	OldestWaitingSince time.Time
+}
+
+type dispatchRow struct {
+	schema.AmbulanceRequest
+	WaitingSince time.Time
+	Priority     float64
+}
+
+func (row *dispatchRow) toDispatchItem() (DispatchItem, error) {
+	request, err := row.AmbulanceRequest.ToPb()
+	if err != nil {
+		return DispatchItem{}, err
+	}
+
+	item := DispatchItem{
+		Request:      request,
+		WaitingSince: row.WaitingSince,
+		Priority:     row.Priority,
+		ClaimedUntil: row.ClaimedUntil,
+	}
+	if row.ClaimedBy != nil {
+		item.ClaimedBy = *row.ClaimedBy
+	}
+	return item, nil
+}
+
+// dispatchPriority ranks a request by severity plus one level for every aging interval it has waited, so a LOW
+// call that has waited long enough is eventually served ahead of newer, more severe calls.
+func dispatchPriority(aging time.Duration) string {
+	if aging <= 0 {
+		return severityRank
+	}
+	return "(" + severityRank + ") + extract(epoch FROM CURRENT_TIMESTAMP - " + waitingSince + ") / " +
+		strconv.FormatFloat(aging.Seconds(), 'f', -1, 64)
+}
+
+func (db *KwikMedicalDBClient) dispatchQueue(tx *gorm.DB, hospitalId uint) *gorm.DB {
+	query := tx.Table("ambulance_requests").
+		Select("ambulance_requests.*, "+waitingSince+" AS waiting_since, "+dispatchPriority(db.config.DispatchAgingInterval)+" AS priority").
+		Joins("INNER JOIN emergency_calls ON emergency_calls.call_id = ambulance_requests.emergency_call_id").
+		Where("ambulance_requests.status = ?", schema.ReqPending).
+		Where("ambulance_requests.ambulance_id IS NULL")
+
+	if hospitalId != 0 {
+		query = query.Where("ambulance_requests.hospital_id = ?", hospitalId)
+	}
+
+	return query.Order("priority DESC, waiting_since ASC, ambulance_requests.request_id ASC")
+}
+
+// PeekDispatchQueue lists the pending requests in the order they will be dequeued, including those currently
+// claimed by a dispatcher. A hospitalId of 0 lists the queue across all hospitals.
+func (db *KwikMedicalDBClient) PeekDispatchQueue(hospitalId uint, limit int) ([]DispatchItem, error) {
+	query := db.dispatchQueue(db.gormDb, hospitalId)
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	var rows []dispatchRow
+	if err := query.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	items := make([]DispatchItem, len(rows))
+	for i := range rows {
+		var err error
+		if items[i], err = rows[i].toDispatchItem(); err != nil {
+			return nil, err
+		}
+	}
+
+	return items, nil
+}
+
+// DequeueDispatch claims the highest priority unclaimed request for the dispatcher. Rows locked by other
+// dispatchers are skipped rather than waited on, so any number of dispatchers can dequeue concurrently without
+// being handed the same request. The claim lapses after the configured timeout unless the request is assigned
+// an ambulance or released first. Nil is returned when there is nothing to dispatch.
+func (db *KwikMedicalDBClient) DequeueDispatch(dispatcher string, hospitalId uint) (*DispatchItem, error) {
+	if dispatcher == "" {
+		return nil, errors.New("a dispatcher is required to dequeue")
+	}
+
+	var item *DispatchItem
+	err := db.DbTransaction(func(tx *gorm.DB) error {
+		var row dispatchRow
+		err := db.dispatchQueue(tx, hospitalId).
+			Where("(ambulance_requests.claimed_until IS NULL OR ambulance_requests.claimed_until < CURRENT_TIMESTAMP)").
+			Clauses(clause.Locking{
+				Strength: "UPDATE",
+				Table:    clause.Table{Name: "ambulance_requests"},
+				Options:  "SKIP LOCKED",
+			}).
+			Limit(1).
+			Take(&row).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		// the claim is timed by the database clock, the same one it is compared against when dequeuing
+		err = tx.Table("ambulance_requests").
+			Where("request_id = ?", row.RequestID).
+			Updates(map[string]interface{}{
+				"claimed_by":    dispatcher,
+				"claimed_until": gorm.Expr("CURRENT_TIMESTAMP + ? * INTERVAL '1 second'", db.config.DispatchClaimTimeout.Seconds()),
+			}).Error
+		if err != nil {
+			return err
+		}
+
+		var claimedUntil time.Time
+		err = tx.Table("ambulance_requests").
+			Select("claimed_until").
+			Where("request_id = ?", row.RequestID).
+			Scan(&claimedUntil).Error
+		if err != nil {
+			return err
+		}
+
+		row.ClaimedBy = &dispatcher
+		row.ClaimedUntil = &claimedUntil
+		dispatchItem, err := row.toDispatchItem()
+		if err != nil {
+			return err
+		}
+		item = &dispatchItem
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+// ReleaseDispatch hands a claimed request back to the queue, e.g. when the dispatcher could not find an ambulance
+func (db *KwikMedicalDBClient) ReleaseDispatch(dispatcher string, requestId uint) error {
+	result := db.gormDb.Table("ambulance_requests").
+		Where("request_id = ?", requestId).
+		Where("claimed_by = ?", dispatcher).
+		Updates(map[string]interface{}{
+			"claimed_by":    nil,
+			"claimed_until": nil,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("request is not claimed by this dispatcher")
+	}
+
+	return nil
+}
+
+func (db *KwikMedicalDBClient) GetDispatchQueueDepth() ([]DispatchQueueDepth, error) {
+	var depths []DispatchQueueDepth
+
+	err := db.gormDb.Table("ambulance_requests").
+		Select(`coalesce(ambulance_requests.hospital_id, 0) AS hospital_id,
+			count(*) AS depth,
+			count(*) FILTER (WHERE ambulance_requests.claimed_until >= CURRENT_TIMESTAMP) AS claimed,
+			min(`+waitingSince+`) AS oldest_waiting_since`).
+		Joins("INNER JOIN emergency_calls ON emergency_calls.call_id = ambulance_requests.emergency_call_id").
+		Where("ambulance_requests.status = ?", schema.ReqPending).
+		Where("ambulance_requests.ambulance_id IS NULL").
+		Group("coalesce(ambulance_requests.hospital_id, 0)").
+		Order("depth DESC").
+		Scan(&depths).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return depths, nil
+}
@@ -0,0 +1,504 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/config"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/schema"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+type duplicatePairRow struct {
+	PatientID        uint
+	DuplicateID      uint
+	FirstNameScore   *float64
+	LastNameScore    *float64
+	AddressScore     *float64
+	DateOfBirthScore *float64
+	PhoneNumberScore *float64
+}
+
+func (row *duplicatePairRow) scores() map[string]*float64 {
+	return map[string]*float64{
+		MatchedFirstName:   row.FirstNameScore,
+		MatchedLastName:    row.LastNameScore,
+		MatchedAddress:     row.AddressScore,
+		MatchedDateOfBirth: row.DateOfBirthScore,
+		MatchedPhoneNumber: row.PhoneNumberScore,
+	}
+}
+
+// ProposeDuplicatePatients compares every pair of patients with similar sounding surnames using the same
+// scoring as FindPatientCandidates and stores pairs above the configured threshold for review. Pairs that
+// have previously been dismissed or merged are not proposed again. It is intended to be run as a periodic job.
+func (db *KwikMedicalDBClient) ProposeDuplicatePatients() ([]schema.DuplicatePatientProposal, error) {
+	query := `
+	SELECT a.patient_id AS patient_id,
+		b.patient_id AS duplicate_id,
+		` + phoneticNameScore("a.first_name", "b.first_name") + ` AS first_name_score,
+		` + phoneticNameScore("a.last_name", "b.last_name") + ` AS last_name_score,
+		CASE WHEN a.address IS NULL OR b.address IS NULL THEN NULL
+			ELSE similarity(lower(a.address), lower(b.address)) END AS address_score,
+		CASE WHEN a.date_of_birth IS NULL OR b.date_of_birth IS NULL THEN NULL
+			WHEN a.date_of_birth = b.date_of_birth THEN 1 ELSE 0 END AS date_of_birth_score,
+		CASE WHEN coalesce(a.phone_number, '') = '' OR coalesce(b.phone_number, '') = '' THEN NULL
+			WHEN right(regexp_replace(a.phone_number, '\D', '', 'g'), 10) = right(regexp_replace(b.phone_number, '\D', '', 'g'), 10) THEN 1
+			ELSE 0 END AS phone_number_score
+	FROM patients a
+	INNER JOIN patients b ON a.patient_id < b.patient_id
+		AND (lower(a.last_name) % lower(b.last_name) OR dmetaphone(a.last_name) = dmetaphone(b.last_name))
+	WHERE NOT EXISTS (
+		SELECT 1 FROM duplicate_patient_proposals p
+		WHERE p.patient_id = a.patient_id AND p.duplicate_id = b.patient_id AND p.status <> ?
+	)
+`
+
+	var rows []duplicatePairRow
+	err := db.gormDb.Raw(query, schema.DuplicateProposed).Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("error finding duplicate patients: %w", err)
+	}
+
+	var proposals []schema.DuplicatePatientProposal
+	for i := range rows {
+		confidence, matchedFields := scorePatientFields(rows[i].scores())
+		if confidence < db.patientDuplicateThreshold() {
+			continue
+		}
+
+		proposals = append(proposals, schema.DuplicatePatientProposal{
+			PatientID:     rows[i].PatientID,
+			DuplicateID:   rows[i].DuplicateID,
+			Confidence:    confidence,
+			MatchedFields: matchedFields,
+			Status:        schema.DuplicateProposed,
+		})
+	}
+
+	if len(proposals) == 0 {
+		return nil, nil
+	}
+
+	err = db.gormDb.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "patient_id"}, {Name: "duplicate_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"confidence", "matched_fields", "updated_at"}),
+	}).Create(&proposals).Error
+	if err != nil {
+		return nil, err
+	}
+
+	db.logger.Debug("Proposed duplicate patients", zap.Int("count", len(proposals)))
+
+	return proposals, nil
+}
+
+func (db *KwikMedicalDBClient) GetDuplicatePatientProposals() ([]schema.DuplicatePatientProposal, error) {
+	var proposals []schema.DuplicatePatientProposal
+
+	err := db.gormDb.Where("status = ?", schema.DuplicateProposed).
+		Order("confidence DESC").
+		Find(&proposals).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return proposals, nil
+}
+
+func (db *KwikMedicalDBClient) DismissDuplicatePatientProposal(proposalId uint) error {
+	return db.gormDb.Model(&schema.DuplicatePatientProposal{}).
+		Where("proposal_id = ?", proposalId).
+		Update("status", schema.DuplicateDismissed).Error
+}
+
+// MergePatients folds the duplicate patient into the survivor: emergency calls, and with them their callouts,
+// are re-pointed, medical record arrays are merged without duplicates and the duplicate patient is removed. Everything that is
+// changed is snapshotted in patient_merges so UnmergePatients can revert it within the retention window.
+func (db *KwikMedicalDBClient) MergePatients(survivorID uint, duplicateID uint) (*schema.PatientMerge, error) {
+	if survivorID == duplicateID {
+		return nil, errors.New("cannot merge a patient into itself")
+	}
+
+	var merge schema.PatientMerge
+	err := db.DbTransaction(func(tx *gorm.DB) error {
+		if err := setChangedBy(tx, fmt.Sprintf("merge of patient %d into %d", duplicateID, survivorID)); err != nil {
+			return err
+		}
+
+		var patients []schema.Patient
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("patient_id IN ?", []uint{survivorID, duplicateID}).
+			Find(&patients).Error; err != nil {
+			return err
+		}
+		if len(patients) != 2 {
+			return errors.New("patient not found")
+		}
+
+		var duplicate schema.Patient
+		for _, patient := range patients {
+			if patient.PatientID == duplicateID {
+				duplicate = patient
+			}
+		}
+
+		var survivorRecord schema.MedicalRecord
+		err := tx.Where("patient_id = ?", survivorID).
+			Order("last_updated DESC").
+			First(&survivorRecord).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		var duplicateRecords []schema.MedicalRecord
+		if err = tx.Where("patient_id = ?", duplicateID).Find(&duplicateRecords).Error; err != nil {
+			return err
+		}
+
+		duplicateRecordIDs := make([]uint, len(duplicateRecords))
+		for i, record := range duplicateRecords {
+			duplicateRecordIDs[i] = record.RecordID
+		}
+		duplicateEntries, err := getClinicalEntriesByRecord(tx, duplicateRecordIDs...)
+		if err != nil {
+			return err
+		}
+
+		// snapshot the state of both patients before anything is changed
+		if merge.DuplicatePatient, err = marshalSnapshot(duplicate); err != nil {
+			return err
+		}
+		if merge.DuplicateRecords, err = marshalSnapshot(duplicateRecords); err != nil {
+			return err
+		}
+		if merge.SurvivorRecord, err = marshalSnapshot(survivorRecord); err != nil {
+			return err
+		}
+		if merge.DuplicateEntries, err = marshalSnapshot(duplicateEntries); err != nil {
+			return err
+		}
+
+		var movedCallIDs []int64
+		if err = tx.Raw(
+			`UPDATE emergency_calls SET patient_id = ? WHERE patient_id = ? RETURNING call_id`,
+			survivorID, duplicateID).Scan(&movedCallIDs).Error; err != nil {
+			return err
+		}
+
+		survivorRecord.PatientID = survivorID
+		for _, record := range duplicateRecords {
+			survivorRecord.Conditions = appendUnique(survivorRecord.Conditions, record.Conditions...)
+			survivorRecord.Medications = appendUnique(survivorRecord.Medications, record.Medications...)
+			survivorRecord.Allergies = appendUnique(survivorRecord.Allergies, record.Allergies...)
+			survivorRecord.Notes = appendUnique(survivorRecord.Notes, record.Notes...)
+		}
+		if survivorRecord.RecordID != 0 || len(duplicateRecords) > 0 {
+			if err = tx.Save(&survivorRecord).Error; err != nil {
+				return err
+			}
+		}
+
+		if err = mergeClinicalEntries(tx, survivorRecord.RecordID, duplicateEntries); err != nil {
+			return err
+		}
+
+		// removing the patient cascades to its medical records, which now live on in the survivor's record
+		if err = tx.Delete(&schema.Patient{}, duplicateID).Error; err != nil {
+			return err
+		}
+
+		merge.SurvivorID = survivorID
+		merge.DuplicateID = duplicateID
+		merge.MergedRecordID = survivorRecord.RecordID
+		merge.MovedCallIDs = movedCallIDs
+		merge.ExpiresAt = time.Now().Add(db.patientMergeRetention())
+		if err = tx.Create(&merge).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&schema.DuplicatePatientProposal{}).
+			Where("(patient_id = ? AND duplicate_id = ?) OR (patient_id = ? AND duplicate_id = ?)",
+				survivorID, duplicateID, duplicateID, survivorID).
+			Update("status", schema.DuplicateMerged).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	db.logger.Debug("Merged patients",
+		zap.Uint("survivor", survivorID),
+		zap.Uint("duplicate", duplicateID),
+		zap.Uint("merge", merge.MergeID))
+
+	return &merge, nil
+}
+
+// UnmergePatients reverts a merge that is still within its retention window. The duplicate patient and its
+// medical records are restored with their original IDs, its emergency calls are moved back and anything it
+// contributed to the survivor's medical record is removed, while changes made to that record since are kept.
+func (db *KwikMedicalDBClient) UnmergePatients(mergeID uint) error {
+	return db.DbTransaction(func(tx *gorm.DB) error {
+		var merge schema.PatientMerge
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&merge, mergeID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("no patient merge found with id %d", mergeID)
+			}
+			return err
+		}
+		if err := setChangedBy(tx, fmt.Sprintf("revert of patient merge %d", mergeID)); err != nil {
+			return err
+		}
+		if merge.RevertedAt != nil {
+			return fmt.Errorf("patient merge %d has already been reverted", mergeID)
+		}
+		if time.Now().After(merge.ExpiresAt) {
+			return fmt.Errorf("patient merge %d expired at %s and can no longer be reverted", mergeID, merge.ExpiresAt)
+		}
+
+		var (
+			duplicate        schema.Patient
+			duplicateRecords []schema.MedicalRecord
+			survivorBefore   schema.MedicalRecord
+		)
+		if err := json.Unmarshal([]byte(merge.DuplicatePatient), &duplicate); err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(merge.DuplicateRecords), &duplicateRecords); err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(merge.SurvivorRecord), &survivorBefore); err != nil {
+			return err
+		}
+
+		if err := tx.Create(&duplicate).Error; err != nil {
+			return err
+		}
+		if len(duplicateRecords) > 0 {
+			if err := tx.Create(&duplicateRecords).Error; err != nil {
+				return err
+			}
+		}
+
+		if merge.DuplicateEntries != "" {
+			var duplicateEntries schema.ClinicalEntries
+			if err := json.Unmarshal([]byte(merge.DuplicateEntries), &duplicateEntries); err != nil {
+				return err
+			}
+			if err := restoreClinicalEntries(tx, &duplicateEntries); err != nil {
+				return err
+			}
+		}
+
+		if len(merge.MovedCallIDs) > 0 {
+			if err := tx.Table("emergency_calls").
+				Where("call_id IN ?", []int64(merge.MovedCallIDs)).
+				Update("patient_id", merge.DuplicateID).Error; err != nil {
+				return err
+			}
+		}
+
+		var mergedRecord schema.MedicalRecord
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&mergedRecord, merge.MergedRecordID).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err == nil {
+			for _, record := range duplicateRecords {
+				mergedRecord.Conditions = removeContributed(mergedRecord.Conditions, survivorBefore.Conditions, record.Conditions)
+				mergedRecord.Medications = removeContributed(mergedRecord.Medications, survivorBefore.Medications, record.Medications)
+				mergedRecord.Allergies = removeContributed(mergedRecord.Allergies, survivorBefore.Allergies, record.Allergies)
+				mergedRecord.Notes = removeContributed(mergedRecord.Notes, survivorBefore.Notes, record.Notes)
+			}
+			if err = tx.Save(&mergedRecord).Error; err != nil {
+				return err
+			}
+		}
+
+		if err = tx.Model(&schema.DuplicatePatientProposal{}).
+			Where("(patient_id = ? AND duplicate_id = ?) OR (patient_id = ? AND duplicate_id = ?)",
+				merge.SurvivorID, merge.DuplicateID, merge.DuplicateID, merge.SurvivorID).
+			Update("status", schema.DuplicateDismissed).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&merge).Update("reverted_at", time.Now()).Error
+	})
+}
+
+// PurgeExpiredPatientMerges removes merge snapshots that are past their retention window.
+func (db *KwikMedicalDBClient) PurgeExpiredPatientMerges() (int64, error) {
+	result := db.gormDb.Where("expires_at < ?", time.Now()).Delete(&schema.PatientMerge{})
+	return result.RowsAffected, result.Error
+}
+
+// mergeClinicalEntries moves the duplicate's structured entries onto the survivor's record, entries the survivor
+// already has an equivalent of are left behind to be removed along with the duplicate.
+func mergeClinicalEntries(tx *gorm.DB, survivorRecordID uint, duplicateEntries *schema.ClinicalEntries) error {
+	if survivorRecordID == 0 {
+		return nil
+	}
+
+	survivorEntries, err := getClinicalEntriesByRecord(tx, survivorRecordID)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]struct{})
+	for _, entry := range survivorEntries.Conditions {
+		keys["condition:"+entry.Key()] = struct{}{}
+	}
+	for _, entry := range survivorEntries.Medications {
+		keys["medication:"+entry.Key()] = struct{}{}
+	}
+	for _, entry := range survivorEntries.Allergies {
+		keys["allergy:"+entry.Key()] = struct{}{}
+	}
+
+	var conditionIDs, medicationIDs, allergyIDs []uint
+	for _, entry := range duplicateEntries.Conditions {
+		if _, ok := keys["condition:"+entry.Key()]; !ok {
+			keys["condition:"+entry.Key()] = struct{}{}
+			conditionIDs = append(conditionIDs, entry.ConditionID)
+		}
+	}
+	for _, entry := range duplicateEntries.Medications {
+		if _, ok := keys["medication:"+entry.Key()]; !ok {
+			keys["medication:"+entry.Key()] = struct{}{}
+			medicationIDs = append(medicationIDs, entry.MedicationID)
+		}
+	}
+	for _, entry := range duplicateEntries.Allergies {
+		if _, ok := keys["allergy:"+entry.Key()]; !ok {
+			keys["allergy:"+entry.Key()] = struct{}{}
+			allergyIDs = append(allergyIDs, entry.AllergyID)
+		}
+	}
+
+	if len(conditionIDs) > 0 {
+		if err = tx.Model(&schema.MedicalCondition{}).Where("condition_id IN ?", conditionIDs).
+			Update("record_id", survivorRecordID).Error; err != nil {
+			return err
+		}
+	}
+	if len(medicationIDs) > 0 {
+		if err = tx.Model(&schema.MedicalMedication{}).Where("medication_id IN ?", medicationIDs).
+			Update("record_id", survivorRecordID).Error; err != nil {
+			return err
+		}
+	}
+	if len(allergyIDs) > 0 {
+		if err = tx.Model(&schema.MedicalAllergy{}).Where("allergy_id IN ?", allergyIDs).
+			Update("record_id", survivorRecordID).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restoreClinicalEntries puts the duplicate's structured entries back as they were before the merge, taking
+// any that were moved away from the survivor's record.
+func restoreClinicalEntries(tx *gorm.DB, entries *schema.ClinicalEntries) error {
+	for _, entry := range entries.Conditions {
+		if err := tx.Delete(&schema.MedicalCondition{}, entry.ConditionID).Error; err != nil {
+			return err
+		}
+	}
+	for _, entry := range entries.Medications {
+		if err := tx.Delete(&schema.MedicalMedication{}, entry.MedicationID).Error; err != nil {
+			return err
+		}
+	}
+	for _, entry := range entries.Allergies {
+		if err := tx.Delete(&schema.MedicalAllergy{}, entry.AllergyID).Error; err != nil {
+			return err
+		}
+	}
+
+	if len(entries.Conditions) > 0 {
+		if err := tx.Create(&entries.Conditions).Error; err != nil {
+			return err
+		}
+	}
+	if len(entries.Medications) > 0 {
+		if err := tx.Create(&entries.Medications).Error; err != nil {
+			return err
+		}
+	}
+	if len(entries.Allergies) > 0 {
+		if err := tx.Create(&entries.Allergies).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func marshalSnapshot(value any) (string, error) {
+	snapshot, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to snapshot patient merge: %w", err)
+	}
+	return string(snapshot), nil
+}
+
+// appendUnique appends the values not already present, preserving the order they were first seen in
+func appendUnique[S ~[]T, T comparable](existing S, values ...T) S {
+	seen := make(map[T]struct{}, len(existing))
+	for _, value := range existing {
+		seen[value] = struct{}{}
+	}
+
+	for _, value := range values {
+		if _, ok := seen[value]; ok {
+			continue
+		}
+		seen[value] = struct{}{}
+		existing = append(existing, value)
+	}
+
+	return existing
+}
+
+// removeContributed removes the values that were contributed by a merge, i.e. present in contributed but not before
+func removeContributed[S ~[]T, T comparable](current S, before S, contributed S) S {
+	keep := make(map[T]struct{}, len(before))
+	for _, value := range before {
+		keep[value] = struct{}{}
+	}
+
+	remove := make(map[T]struct{}, len(contributed))
+	for _, value := range contributed {
+		if _, ok := keep[value]; !ok {
+			remove[value] = struct{}{}
+		}
+	}
+
+	result := make(S, 0, len(current))
+	for _, value := range current {
+		if _, ok := remove[value]; !ok {
+			result = append(result, value)
+		}
+	}
+
+	return result
+}
+
+// patientDuplicateThreshold and patientMergeRetention fall back to the defaults for configs built without them
+func (db *KwikMedicalDBClient) patientDuplicateThreshold() float64 {
+	if db.config.PatientDuplicateThreshold == 0 {
+		return config.PatientDuplicateThresholdDefault
+	}
+	return db.config.PatientDuplicateThreshold
+}
+
+func (db *KwikMedicalDBClient) patientMergeRetention() time.Duration {
+	if db.config.PatientMergeRetention <= 0 {
+		return config.PatientMergeRetentionDefault
+	}
+	return db.config.PatientMergeRetention
+}
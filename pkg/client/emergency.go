@@ -1,9 +1,13 @@
 package client
 
 import (
+	"errors"
+	"fmt"
 	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/schema"
 	"github.com/jamieyoung5/kwikmedical-eventstream/pb"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
 )
 
 func (db *KwikMedicalDBClient) GetAmbulanceRequests(hospitalId int) ([]*pb.AmbulanceRequest, []*pb.AmbulanceRequest, error) {
@@ -28,30 +32,72 @@ func (db *KwikMedicalDBClient) GetAmbulanceRequests(hospitalId int) ([]*pb.Ambul
 
 	inProgress := make([]*pb.AmbulanceRequest, len(inProgressRequests))
 	for i, request := range inProgressRequests {
-		inProgress[i] = request.ToPb()
+		if inProgress[i], err = request.ToPb(); err != nil {
+			return nil, nil, err
+		}
 	}
 
 	completed := make([]*pb.AmbulanceRequest, len(completedRequests))
 	for i, request := range completedRequests {
-		completed[i] = request.ToPb()
+		if completed[i], err = request.ToPb(); err != nil {
+			return nil, nil, err
+		}
 	}
 
 	return inProgress, completed, nil
 }
 
-func (db *KwikMedicalDBClient) GetCurrentAmbulanceRequest(ambulanceId int) (*pb.AmbulanceRequest, error) {
-	var request schema.AmbulanceRequest
+// CurrentAmbulanceRequest is the request an ambulance is on along with the crew assigned to it and the crew on
+// shift on it, who dispatch checked before sending it
+type CurrentAmbulanceRequest struct {
+	Request    *pb.AmbulanceRequest
+	Crew       []*pb.AmbulanceStaff
+	OnDutyCrew []*pb.AmbulanceStaff
+}
 
-	err := db.gormDb.Table("ambulance_requests").
-		Where("ambulance_id = ?", ambulanceId).
-		Where("status = ?", "ACCEPTED").
-		First(&request).Error
+// GetCurrentAmbulanceRequest returns the request the ambulance has accepted along with its crew, as given by
+// GetCrewForAmbulance, and its crew on shift, read together with the request.
+func (db *KwikMedicalDBClient) GetCurrentAmbulanceRequest(ambulanceId int) (*CurrentAmbulanceRequest, error) {
+	var (
+		request schema.AmbulanceRequest
+		crew    []schema.AmbulanceStaff
+		onDuty  []AmbulanceCrew
+	)
 
+	err := db.DbTransaction(func(tx *gorm.DB) error {
+		err := tx.Table("ambulance_requests").
+			Where("ambulance_id = ?", ambulanceId).
+			Where("status = ?", "ACCEPTED").
+			First(&request).Error
+		if err != nil {
+			return err
+		}
+
+		if crew, err = getCrew(tx, uint(ambulanceId)); err != nil {
+			return err
+		}
+
+		onDuty, err = getOnDutyCrew(tx, gorm.Expr("CURRENT_TIMESTAMP"), uint(ambulanceId))
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return request.ToPb(), nil
+	current := &CurrentAmbulanceRequest{Crew: make([]*pb.AmbulanceStaff, len(crew))}
+	if current.Request, err = request.ToPb(); err != nil {
+		return nil, err
+	}
+	for i := range crew {
+		if current.Crew[i], err = crew[i].ToPb(); err != nil {
+			return nil, err
+		}
+	}
+	if len(onDuty) > 0 {
+		current.OnDutyCrew = onDuty[0].Crew
+	}
+
+	return current, nil
 }
 
 func (db *KwikMedicalDBClient) AssignAmbulance(requestId int) (*int32, error) {
@@ -62,6 +108,8 @@ func (db *KwikMedicalDBClient) AssignAmbulance(requestId int) (*int32, error) {
 			Joins("INNER JOIN ambulance_requests ON ambulances.regional_hospital_id = ambulance_requests.hospital_id").
 			Where("ambulance_requests.request_id = ?", requestId).
 			Where("ambulances.status = ?", "AVAILABLE").
+			Where(withOnDutyCrew(tx)).
+			Where(withoutImminentMaintenance, db.config.LongDistanceThreshold, db.config.MaintenanceLookahead.Seconds()).
 			Limit(1).
 			Scan(&ambulanceID).Error
 
@@ -104,15 +152,26 @@ func (db *KwikMedicalDBClient) AssignAmbulance(requestId int) (*int32, error) {
 	return ambulanceID, nil
 }
 
+// UnassignAmbulance completes a request and frees the ambulance that was sent to it. An ambulance with
+// maintenance that fell due while it was on the call goes straight into maintenance instead.
 func (db *KwikMedicalDBClient) UnassignAmbulance(requestId int) error {
 	return db.DbTransaction(func(tx *gorm.DB) error {
-		err := tx.Table("ambulances").
-			Where("ambulances.regional_hospital_id = (SELECT hospital_id FROM ambulance_requests WHERE request_id = ?)", requestId).
-			Update("status", "AVAILABLE").Error
+		var ambulance schema.Ambulance
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("ambulance_id = (SELECT ambulance_id FROM ambulance_requests WHERE request_id = ?)", requestId).
+			Limit(1).
+			Find(&ambulance).Error
 		if err != nil {
 			return err
 		}
 
+		// ambulances out for maintenance stay out until it is completed
+		if ambulance.AmbulanceID != 0 && ambulance.Status == schema.OnCall {
+			if err = db.releaseFromCall(tx, ambulance.AmbulanceID); err != nil {
+				return err
+			}
+		}
+
 		err = tx.Table("ambulance_requests").
 			Where("request_id = ?", requestId).
 			Update("status", "COMPLETED").Error
@@ -124,21 +183,226 @@ func (db *KwikMedicalDBClient) UnassignAmbulance(requestId int) error {
 }
 
 func (db *KwikMedicalDBClient) CreateNewAmbulanceRequest(request *pb.AmbulanceRequest) (int32, error) {
-	ambulanceRequest := schema.AmbulanceRequestPbToGorm(request)
+	ambulanceRequest, err := schema.AmbulanceRequestPbToGorm(request)
+	if err != nil {
+		return 0, err
+	}
 
-	if err := db.gormDb.Create(&ambulanceRequest).Error; err != nil {
+	if err = db.gormDb.Create(&ambulanceRequest).Error; err != nil {
 		return 0, err
 	}
 
 	return int32(ambulanceRequest.RequestID), nil
 }
 
+// InsertNewEmergencyCall inserts a call, checking for calls already made about the same event according to the
+// configured duplicate handling. When duplicates are handed back a *DuplicateCallsError is returned and the
+// call is not inserted.
 func (db *KwikMedicalDBClient) InsertNewEmergencyCall(call *pb.EmergencyCall) (int32, error) {
-	emergencyCall := schema.EmergencyCallPbToGorm(call)
+	emergencyCall, err := schema.EmergencyCallPbToGorm(call)
+	if err != nil {
+		return 0, err
+	}
+
+	handling := db.config.CallDuplicateHandling
+	if handling == "" || handling == DuplicateCallsIgnore {
+		if err := db.gormDb.Create(&emergencyCall).Error; err != nil {
+			return 0, err
+		}
+		return int32(emergencyCall.CallID), nil
+	}
+
+	err = db.DbTransaction(func(tx *gorm.DB) error {
+		candidates, err := findDuplicateCalls(tx, db.config.CallDuplicateWindow, db.config.CallDuplicateRadius,
+			db.config.CallDuplicateSimilarity, call)
+		if err != nil {
+			return err
+		}
+
+		if len(candidates) > 0 {
+			switch handling {
+			case DuplicateCallsReturn:
+				return &DuplicateCallsError{Candidates: candidates}
+			case DuplicateCallsLink:
+				parentId, err := rootCallID(tx, uint(candidates[0].Call.CallId))
+				if err != nil {
+					return err
+				}
+				emergencyCall.ParentCallID = &parentId
+				if emergencyCall.IncidentID, err = callIncidentID(tx, parentId); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("unknown duplicate call handling %q", handling)
+			}
+		}
 
-	if err := db.gormDb.Create(&emergencyCall).Error; err != nil {
+		return tx.Create(&emergencyCall).Error
+	})
+	if err != nil {
 		return 0, err
 	}
 
 	return int32(emergencyCall.CallID), nil
 }
+
+type EmergencyCallFilter struct {
+	Statuses   []pb.EmergencyCallStatus
+	Severities []pb.InjurySeverity
+	From       *time.Time
+	To         *time.Time
+	// HospitalID matches calls whose latest ambulance request was made to the hospital
+	HospitalID uint
+	Limit      int
+}
+
+// emergencyCallRow is an emergency call along with the ambulance and hospital of its latest ambulance request
+type emergencyCallRow struct {
+	schema.EmergencyCall
+	AssignedAmbulanceID *uint
+	AssignedHospitalID  *uint
+}
+
+func (row *emergencyCallRow) ToPb() (*pb.EmergencyCall, error) {
+	call, err := row.EmergencyCall.ToPb()
+	if err != nil {
+		return nil, err
+	}
+	if row.AssignedAmbulanceID != nil {
+		call.AssignedAmbulanceId = int32(*row.AssignedAmbulanceID)
+	}
+	if row.AssignedHospitalID != nil {
+		call.AssignedHospitalId = int32(*row.AssignedHospitalID)
+	}
+	return call, nil
+}
+
+func (db *KwikMedicalDBClient) emergencyCallsWithAssignment() *gorm.DB {
+	return db.gormDb.Table("emergency_calls").
+		Select("emergency_calls.*, latest.ambulance_id AS assigned_ambulance_id, latest.hospital_id AS assigned_hospital_id").
+		Joins(`LEFT JOIN LATERAL (
+			SELECT ambulance_id, hospital_id FROM ambulance_requests
+			WHERE ambulance_requests.emergency_call_id = emergency_calls.call_id
+			ORDER BY created_at DESC
+			LIMIT 1
+		) latest ON true`)
+}
+
+func (db *KwikMedicalDBClient) GetEmergencyCall(callId uint) (*pb.EmergencyCall, error) {
+	var call emergencyCallRow
+
+	err := db.emergencyCallsWithAssignment().
+		Where("emergency_calls.call_id = ?", callId).
+		Take(&call).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("no emergency call found with id %d", callId)
+		}
+		return nil, err
+	}
+
+	return call.ToPb()
+}
+
+func (db *KwikMedicalDBClient) ListEmergencyCalls(filter EmergencyCallFilter) ([]*pb.EmergencyCall, error) {
+	query := db.emergencyCallsWithAssignment()
+
+	if len(filter.Statuses) > 0 {
+		statuses := make([]schema.EmergencyCallStatus, len(filter.Statuses))
+		for i, status := range filter.Statuses {
+			var err error
+			if statuses[i], err = schema.EmergencyCallStatusFromPb(status); err != nil {
+				return nil, err
+			}
+		}
+		query = query.Where("emergency_calls.status IN ?", statuses)
+	}
+	if len(filter.Severities) > 0 {
+		severities := make([]schema.InjurySeverity, len(filter.Severities))
+		for i, severity := range filter.Severities {
+			var err error
+			if severities[i], err = schema.InjurySeverityFromPb(severity); err != nil {
+				return nil, err
+			}
+		}
+		query = query.Where("emergency_calls.severity IN ?", severities)
+	}
+	if filter.From != nil {
+		query = query.Where("emergency_calls.call_time >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("emergency_calls.call_time <= ?", *filter.To)
+	}
+	if filter.HospitalID != 0 {
+		query = query.Where("latest.hospital_id = ?", filter.HospitalID)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var rows []emergencyCallRow
+	if err := query.Order("emergency_calls.call_time DESC").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	calls := make([]*pb.EmergencyCall, len(rows))
+	for i := range rows {
+		var err error
+		if calls[i], err = rows[i].ToPb(); err != nil {
+			return nil, err
+		}
+	}
+
+	return calls, nil
+}
+
+func (db *KwikMedicalDBClient) UpdateEmergencyCallStatus(callId uint, status pb.EmergencyCallStatus) error {
+	if status == pb.EmergencyCallStatus_UNKNOWN_EMERGENCY_CALL_STATUS {
+		return errors.New("cannot set an emergency call to an unknown status")
+	}
+	callStatus, err := schema.EmergencyCallStatusFromPb(status)
+	if err != nil {
+		return err
+	}
+
+	result := db.gormDb.Table("emergency_calls").
+		Where("call_id = ?", callId).
+		Update("status", callStatus)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("no emergency call found with id %d", callId)
+	}
+
+	return nil
+}
+
+// LinkPatientToCall links a call made before the patient was identified to their patient record, which also
+// brings the call's callouts into the patient's medical history.
+func (db *KwikMedicalDBClient) LinkPatientToCall(callId uint, patientId uint) error {
+	return db.DbTransaction(func(tx *gorm.DB) error {
+		var patient schema.Patient
+		if err := tx.Select("patient_id", "nhs_number").First(&patient, patientId).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("patient not found")
+			}
+			return err
+		}
+
+		result := tx.Table("emergency_calls").
+			Where("call_id = ?", callId).
+			Updates(map[string]interface{}{
+				"patient_id": patient.PatientID,
+				"nhs_number": patient.NHSNumber,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("no emergency call found with id %d", callId)
+		}
+
+		return nil
+	})
+}
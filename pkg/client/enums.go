@@ -0,0 +1,68 @@
+package client
+
+import (
+	"fmt"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/schema"
+	"sort"
+	"strings"
+)
+
+// CheckEnums verifies the labels of the database's enum types are exactly the schema's constants, so values read
+// from the database always map onto the protobuf enums
+func (db *KwikMedicalDBClient) CheckEnums() error {
+	typeNames := make([]string, 0, len(schema.DatabaseEnumLabels))
+	for typeName := range schema.DatabaseEnumLabels {
+		typeNames = append(typeNames, typeName)
+	}
+	sort.Strings(typeNames)
+
+	var rows []struct {
+		TypeName string
+		Label    string
+	}
+	err := db.gormDb.Raw(`
+	SELECT pg_type.typname AS type_name, pg_enum.enumlabel AS label
+	FROM pg_enum
+	INNER JOIN pg_type ON pg_type.oid = pg_enum.enumtypid
+	WHERE pg_type.typname IN ?
+	ORDER BY pg_type.typname, pg_enum.enumsortorder`, typeNames).Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	labels := map[string]map[string]bool{}
+	for _, row := range rows {
+		if labels[row.TypeName] == nil {
+			labels[row.TypeName] = map[string]bool{}
+		}
+		labels[row.TypeName][row.Label] = true
+	}
+
+	var mismatches []string
+	for _, typeName := range typeNames {
+		dbLabels, ok := labels[typeName]
+		if !ok {
+			mismatches = append(mismatches, fmt.Sprintf("%s does not exist", typeName))
+			continue
+		}
+
+		expected := map[string]bool{}
+		for _, label := range schema.DatabaseEnumLabels[typeName] {
+			expected[label] = true
+			if !dbLabels[label] {
+				mismatches = append(mismatches, fmt.Sprintf("%s is missing %s", typeName, label))
+			}
+		}
+		for _, row := range rows {
+			if row.TypeName == typeName && !expected[row.Label] {
+				mismatches = append(mismatches, fmt.Sprintf("%s has unexpected label %s", typeName, row.Label))
+			}
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%w: %s", schema.ErrUnknownEnumValue, strings.Join(mismatches, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,185 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/fhir"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/nhs"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/schema"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"io"
+	"os"
+)
+
+type FhirImportResult struct {
+	Created    int
+	Updated    int
+	PatientIDs []uint
+}
+
+// ExportPatientFhir builds a FHIR R4 Bundle, as JSON, of the patient's demographics, structured clinical entries,
+// emergency calls and callouts.
+func (db *KwikMedicalDBClient) ExportPatientFhir(patientId uint) ([]byte, error) {
+	patient, err := db.GetPatientByID(patientId)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := db.GetClinicalEntries(patientId)
+	if err != nil {
+		return nil, err
+	}
+
+	var calls []schema.EmergencyCall
+	if err = db.gormDb.Where("patient_id = ?", patientId).Order("call_time ASC").Find(&calls).Error; err != nil {
+		return nil, err
+	}
+
+	callouts, err := getCalloutsByPatientID(db.gormDb, patientId)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle, err := fhir.NewPatientBundle(fhir.PatientExport{
+		Patient:        patient,
+		Entries:        entries,
+		EmergencyCalls: calls,
+		Callouts:       callouts,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(bundle, "", "  ")
+}
+
+func (db *KwikMedicalDBClient) ImportFhirBundleFile(path string, changedBy string) (*FhirImportResult, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open FHIR bundle: %w", err)
+	}
+	defer file.Close()
+
+	return db.ImportFhirBundle(file, changedBy)
+}
+
+// ImportFhirBundle upserts the patients in a FHIR Bundle, matched on NHS number, and adds their conditions,
+// allergies and medications to their medical records. Entries the patient already has are updated rather than
+// duplicated, so importing the same bundle twice is safe. Every patient and entry is checked before anything is
+// written and the bundle is imported in one transaction, so a bundle that fails leaves nothing behind.
+func (db *KwikMedicalDBClient) ImportFhirBundle(r io.Reader, changedBy string) (*FhirImportResult, error) {
+	bundle, err := fhir.ParseBundle(r)
+	if err != nil {
+		return nil, err
+	}
+
+	imports, err := bundle.Patients()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range imports {
+		if err = validatePatientImport(&imports[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &FhirImportResult{}
+	err = db.DbTransaction(func(tx *gorm.DB) error {
+		for _, patientImport := range imports {
+			patientId, created, err := upsertPatient(tx, patientImport.Patient)
+			if err != nil {
+				return err
+			}
+
+			if created {
+				result.Created++
+			} else {
+				result.Updated++
+			}
+			result.PatientIDs = append(result.PatientIDs, patientId)
+
+			for i := range patientImport.Entries.Conditions {
+				if err = addConditionEntry(tx, patientId, &patientImport.Entries.Conditions[i], changedBy); err != nil {
+					return err
+				}
+			}
+			for i := range patientImport.Entries.Allergies {
+				if err = addAllergyEntry(tx, patientId, &patientImport.Entries.Allergies[i], changedBy); err != nil {
+					return err
+				}
+			}
+			for i := range patientImport.Entries.Medications {
+				if err = addMedicationEntry(tx, patientId, &patientImport.Entries.Medications[i], changedBy); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	db.logger.Debug("Imported FHIR bundle", zap.Int("created", result.Created), zap.Int("updated", result.Updated))
+
+	return result, nil
+}
+
+// validatePatientImport checks a patient's NHS number and entries, normalizing them ready to be written
+func validatePatientImport(patientImport *fhir.PatientImport) error {
+	nhsNumber, err := nhs.Validate(patientImport.Patient.NHSNumber)
+	if err != nil {
+		return err
+	}
+	patientImport.Patient.NHSNumber = nhsNumber
+
+	for i := range patientImport.Entries.Conditions {
+		if err = validateCondition(&patientImport.Entries.Conditions[i]); err != nil {
+			return fmt.Errorf("patient %s: %w", nhsNumber, err)
+		}
+	}
+	for i := range patientImport.Entries.Allergies {
+		if err = validateAllergy(&patientImport.Entries.Allergies[i]); err != nil {
+			return fmt.Errorf("patient %s: %w", nhsNumber, err)
+		}
+	}
+	for i := range patientImport.Entries.Medications {
+		if err = validateMedication(&patientImport.Entries.Medications[i]); err != nil {
+			return fmt.Errorf("patient %s: %w", nhsNumber, err)
+		}
+	}
+	return nil
+}
+
+// upsertPatient creates the patient, or updates the patient with the same NHS number with any details given. The
+// insert does nothing on a conflicting NHS number so that two imports of a new patient cannot both create them.
+func upsertPatient(tx *gorm.DB, patient schema.Patient) (uint, bool, error) {
+	query := tx.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "nhs_number"}}, DoNothing: true})
+	if patient.DateOfBirth == "" {
+		query = query.Omit("date_of_birth")
+	}
+	result := query.Create(&patient)
+	if result.Error != nil {
+		return 0, false, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return patient.PatientID, true, nil
+	}
+
+	var existing schema.Patient
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("nhs_number = ?", patient.NHSNumber).
+		First(&existing).Error
+	if err != nil {
+		return 0, false, err
+	}
+
+	if err = tx.Model(&existing).Updates(patient).Error; err != nil {
+		return 0, false, err
+	}
+
+	return existing.PatientID, false, nil
+}
@@ -0,0 +1,132 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/handover"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/schema"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// BuildHandoverPackage gathers everything the receiving hospital needs about an ambulance request into a single
+// package and records that the handover took place. Each request is handed over once, so building the package
+// again replaces the recorded package rather than recording a second handover.
+func (db *KwikMedicalDBClient) BuildHandoverPackage(requestId uint) (*handover.Package, error) {
+	var (
+		request  schema.AmbulanceRequest
+		call     schema.EmergencyCall
+		hospital schema.RegionalHospital
+		incident *schema.Incident
+		callouts []schema.CallOutDetails
+	)
+
+	err := db.DbTransaction(func(tx *gorm.DB) error {
+		if err := tx.First(&request, requestId).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("no ambulance request found with id %d", requestId)
+			}
+			return err
+		}
+		if request.HospitalID == nil {
+			return fmt.Errorf("ambulance request %d has no receiving hospital", requestId)
+		}
+
+		if err := tx.First(&call, request.EmergencyCallID).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&hospital, *request.HospitalID).Error; err != nil {
+			return err
+		}
+		if call.IncidentID != nil {
+			incident = &schema.Incident{}
+			if err := tx.First(incident, *call.IncidentID).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Where("call_id = ?", call.CallID).Order("created_at ASC").Find(&callouts).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	requestPb, err := request.ToPb()
+	if err != nil {
+		return nil, err
+	}
+	callPb, err := call.ToPb()
+	if err != nil {
+		return nil, err
+	}
+
+	handoverPackage := &handover.Package{
+		GeneratedAt:   time.Now(),
+		Hospital:      hospital.ToPb(),
+		Request:       requestPb,
+		EmergencyCall: callPb,
+		Incident:      incident,
+	}
+	for i := range callouts {
+		handoverPackage.Callouts = append(handoverPackage.Callouts, callouts[i].ToPb())
+	}
+
+	// an unidentified patient is still handed over, just without their history
+	if call.PatientID != nil {
+		patientData, err := db.GetHistoricalPatientDataByID(*call.PatientID)
+		if patientData.Patient == nil {
+			return nil, err
+		}
+		if err != nil {
+			db.logger.Warn("Handing over without medical history", zap.Uint("patient", *call.PatientID), zap.Error(err))
+		}
+
+		handoverPackage.Patient = patientData.Patient.ToPb()
+		if patientData.MedicalRecord != nil {
+			handoverPackage.MedicalRecord = patientData.MedicalRecord.ToPb(patientData.Callouts)
+		}
+		if handoverPackage.Entries, err = db.GetClinicalEntries(*call.PatientID); err != nil {
+			return nil, err
+		}
+		handoverPackage.Alerts = patientData.Alerts
+	}
+
+	packageJson, err := handoverPackage.JSON()
+	if err != nil {
+		return nil, err
+	}
+
+	record := schema.HospitalHandover{
+		RequestID:  request.RequestID,
+		HospitalID: hospital.HospitalID,
+		CallID:     call.CallID,
+		PatientID:  call.PatientID,
+		Package:    string(packageJson),
+	}
+	err = db.gormDb.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "request_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"hospital_id", "call_id", "patient_id", "package"}),
+	}).Create(&record).Error
+	if err != nil {
+		return nil, err
+	}
+	handoverPackage.HandoverID = record.HandoverID
+
+	return handoverPackage, nil
+}
+
+func (db *KwikMedicalDBClient) GetHospitalHandovers(hospitalId uint, from time.Time, to time.Time) ([]schema.HospitalHandover, error) {
+	var handovers []schema.HospitalHandover
+
+	err := db.gormDb.Where("hospital_id = ?", hospitalId).
+		Where("handed_over_at BETWEEN ? AND ?", from, to).
+		Order("handed_over_at DESC").
+		Find(&handovers).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return handovers, nil
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/heatmap"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/schema"
+	"github.com/jamieyoung5/kwikmedical-eventstream/pb"
+	"time"
+)
+
+type CallDensityFilter struct {
+	From       time.Time
+	To         time.Time
+	Severities []pb.InjurySeverity
+	Shape      heatmap.Shape
+	// CellSize is in metres, the configured size is used when it is zero
+	CellSize float64
+}
+
+// GetCallDensity aggregates the locations of the calls made between From and To into a GeoJSON heatmap of call
+// counts. Calls without a location are left out.
+func (db *KwikMedicalDBClient) GetCallDensity(filter CallDensityFilter) (*heatmap.FeatureCollection, error) {
+	shape := filter.Shape
+	if shape == "" {
+		shape = heatmap.Hexagon
+	}
+	cellSize := filter.CellSize
+	if cellSize == 0 {
+		cellSize = db.config.HeatmapCellSize
+	}
+
+	// points are pre-aggregated to roughly 10 metres so only distinct locations leave the database
+	query := db.gormDb.Table("emergency_calls").
+		Select(`round(CAST(CAST(location AS jsonb) ->> 'latitude' AS numeric), 4) AS latitude,
+			round(CAST(CAST(location AS jsonb) ->> 'longitude' AS numeric), 4) AS longitude,
+			count(*) AS weight`).
+		Where("NULLIF(location, '') IS NOT NULL").
+		Where("call_time >= ? AND call_time < ?", filter.From, filter.To)
+
+	if len(filter.Severities) > 0 {
+		severities := make([]schema.InjurySeverity, len(filter.Severities))
+		for i, severity := range filter.Severities {
+			var err error
+			if severities[i], err = schema.InjurySeverityFromPb(severity); err != nil {
+				return nil, err
+			}
+		}
+		query = query.Where("severity IN ?", severities)
+	}
+
+	var points []heatmap.Point
+	err := db.gormDb.Table("(?) AS points", query.Group("1, 2")).
+		Where("NOT (latitude = 0 AND longitude = 0)").
+		Scan(&points).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return heatmap.Aggregate(points, shape, cellSize, db.config.HeatmapReferenceLatitude)
+}
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/schema"
+	"gorm.io/gorm"
+	"time"
+)
+
+type FieldDiff struct {
+	Added   []string
+	Removed []string
+}
+
+type MedicalRecordDiff struct {
+	From        *schema.MedicalRecordVersion
+	To          *schema.MedicalRecordVersion
+	Conditions  FieldDiff
+	Medications FieldDiff
+	Allergies   FieldDiff
+	Notes       FieldDiff
+}
+
+// GetMedicalRecordAt returns the patient's medical record as it was known at the given time, e.g. the time of a
+// past callout.
+func (db *KwikMedicalDBClient) GetMedicalRecordAt(patientId uint, at time.Time) (*schema.MedicalRecordVersion, error) {
+	var version schema.MedicalRecordVersion
+
+	err := db.gormDb.Where("patient_id = ?", patientId).
+		Where("changed_at <= ?", at).
+		Order("changed_at DESC, version_id DESC").
+		First(&version).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("no medical record found for patient_id %d at %s", patientId, at)
+		}
+		return nil, err
+	}
+
+	return &version, nil
+}
+
+func (db *KwikMedicalDBClient) GetMedicalRecordHistory(patientId uint) ([]schema.MedicalRecordVersion, error) {
+	var versions []schema.MedicalRecordVersion
+
+	err := db.gormDb.Where("patient_id = ?", patientId).
+		Order("changed_at ASC, version_id ASC").
+		Find(&versions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// DiffMedicalRecordVersions reports what was added and removed between two versions, by version ID.
+func (db *KwikMedicalDBClient) DiffMedicalRecordVersions(fromVersionId uint, toVersionId uint) (*MedicalRecordDiff, error) {
+	var versions []schema.MedicalRecordVersion
+
+	err := db.gormDb.Where("version_id IN ?", []uint{fromVersionId, toVersionId}).Find(&versions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var from, to *schema.MedicalRecordVersion
+	for i := range versions {
+		if versions[i].VersionID == fromVersionId {
+			from = &versions[i]
+		}
+		if versions[i].VersionID == toVersionId {
+			to = &versions[i]
+		}
+	}
+	if from == nil || to == nil {
+		return nil, errors.New("medical record version not found")
+	}
+
+	return &MedicalRecordDiff{
+		From:        from,
+		To:          to,
+		Conditions:  diffValues(from.Conditions, to.Conditions),
+		Medications: diffValues(from.Medications, to.Medications),
+		Allergies:   diffValues(from.Allergies, to.Allergies),
+		Notes:       diffValues(from.Notes, to.Notes),
+	}, nil
+}
+
+// setChangedBy records who is making the change for the medical record history trigger, it only lasts for the
+// current transaction.
+func setChangedBy(tx *gorm.DB, changedBy string) error {
+	if changedBy == "" {
+		return nil
+	}
+	return tx.Exec(`SELECT set_config('kwikmedical.changed_by', ?, true)`, changedBy).Error
+}
+
+func diffValues(from []string, to []string) FieldDiff {
+	return FieldDiff{
+		Added:   difference(to, from),
+		Removed: difference(from, to),
+	}
+}
+
+// difference returns the values of a that are not in b, preserving their order
+func difference(a []string, b []string) []string {
+	exclude := make(map[string]struct{}, len(b))
+	for _, value := range b {
+		exclude[value] = struct{}{}
+	}
+
+	var result []string
+	for _, value := range a {
+		if _, ok := exclude[value]; !ok {
+			result = append(result, value)
+		}
+	}
+	return result
+}
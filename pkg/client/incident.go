@@ -0,0 +1,317 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/schema"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// IncidentAllocation is an ambulance allocated to one of an incident's ambulance requests
+type IncidentAllocation struct {
+	RequestID   uint
+	AmbulanceID uint
+	HospitalID  *uint
+}
+
+// HospitalCasualtySummary counts an incident's casualties by the hospital their ambulance request was made to.
+// Casualties are counted once per identified patient, or once per call when the patient is not yet known.
+type HospitalCasualtySummary struct {
+	// HospitalID is 0 for casualties that have not been routed to a hospital yet
+	HospitalID   uint
+	HospitalName string
+	Casualties   int
+	Critical     int
+	High         int
+	Moderate     int
+	Low          int
+	Unknown      int
+}
+
+func (db *KwikMedicalDBClient) DeclareIncident(incident *schema.Incident) (uint, error) {
+	if incident.Name == "" {
+		return 0, errors.New("an incident must have a name")
+	}
+	if incident.Severity == "" {
+		incident.Severity = schema.UnknownSeverity
+	}
+	incident.Status = schema.IncidentDeclared
+
+	err := db.DbTransaction(func(tx *gorm.DB) error {
+		if err := tx.Create(incident).Error; err != nil {
+			return err
+		}
+
+		return recordIncidentEvent(tx, schema.IncidentEvent{
+			IncidentID:  incident.IncidentID,
+			EventType:   schema.IncidentDeclaredEvent,
+			Description: fmt.Sprintf("%s declared with severity %s", incident.Name, incident.Severity),
+			RecordedBy:  incident.DeclaredBy,
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return incident.IncidentID, nil
+}
+
+func (db *KwikMedicalDBClient) GetIncident(incidentId uint) (*schema.Incident, error) {
+	var incident schema.Incident
+	if err := db.gormDb.First(&incident, incidentId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("no incident found with id %d", incidentId)
+		}
+		return nil, err
+	}
+
+	return &incident, nil
+}
+
+func (db *KwikMedicalDBClient) GetIncidentTimeline(incidentId uint) ([]schema.IncidentEvent, error) {
+	var events []schema.IncidentEvent
+
+	err := db.gormDb.Where("incident_id = ?", incidentId).
+		Order("occurred_at ASC, event_id ASC").
+		Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// AttachCallToIncident groups a call under an incident, along with any calls linked to it as duplicates
+func (db *KwikMedicalDBClient) AttachCallToIncident(incidentId uint, callId uint, attachedBy string) error {
+	return db.DbTransaction(func(tx *gorm.DB) error {
+		if _, err := lockOpenIncident(tx, incidentId); err != nil {
+			return err
+		}
+
+		rootId, err := rootCallID(tx, callId)
+		if err != nil {
+			return err
+		}
+
+		var attached []uint
+		err = tx.Raw(`UPDATE emergency_calls SET incident_id = ?
+			WHERE (call_id = ? OR parent_call_id = ?) AND incident_id IS DISTINCT FROM ?
+			RETURNING call_id`, incidentId, rootId, rootId, incidentId).
+			Scan(&attached).Error
+		if err != nil {
+			return err
+		}
+
+		for _, attachedId := range attached {
+			err = recordIncidentEvent(tx, schema.IncidentEvent{
+				IncidentID:  incidentId,
+				EventType:   schema.IncidentCallAttached,
+				Description: fmt.Sprintf("call %d attached", attachedId),
+				CallID:      &attachedId,
+				RecordedBy:  attachedBy,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// AllocateAmbulancesToIncident assigns the given ambulances to the incident's pending ambulance requests, most
+// severe first, in a single transaction so that either every ambulance is allocated or none are. There must be a
+// pending request for every ambulance, and every ambulance must be available with a crew on shift and must not be
+// sent further than the long-distance threshold when it is due for maintenance, as with AssignAmbulance.
+func (db *KwikMedicalDBClient) AllocateAmbulancesToIncident(incidentId uint, ambulanceIds []uint, allocatedBy string) ([]IncidentAllocation, error) {
+	if len(ambulanceIds) == 0 {
+		return nil, errors.New("no ambulances given to allocate")
+	}
+
+	var allocations []IncidentAllocation
+	err := db.DbTransaction(func(tx *gorm.DB) error {
+		if _, err := lockOpenIncident(tx, incidentId); err != nil {
+			return err
+		}
+
+		var ambulances []schema.Ambulance
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("ambulance_id IN ?", ambulanceIds).
+			Where("status = ?", schema.Available).
+			Where(withOnDutyCrew(tx)).
+			Order("ambulance_id ASC").
+			Find(&ambulances).Error
+		if err != nil {
+			return err
+		}
+		if len(ambulances) != len(ambulanceIds) {
+			return fmt.Errorf("only %d of the %d ambulances are available with a crew on shift", len(ambulances), len(ambulanceIds))
+		}
+
+		var requests []schema.AmbulanceRequest
+		err = tx.Table("ambulance_requests").
+			Select("ambulance_requests.*").
+			Joins("INNER JOIN emergency_calls ON emergency_calls.call_id = ambulance_requests.emergency_call_id").
+			Where("emergency_calls.incident_id = ?", incidentId).
+			Where("ambulance_requests.status = ?", schema.ReqPending).
+			Where("ambulance_requests.ambulance_id IS NULL").
+			Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "ambulance_requests"}}).
+			Order(severityRank + " DESC, " + waitingSince + " ASC, ambulance_requests.request_id ASC").
+			Find(&requests).Error
+		if err != nil {
+			return err
+		}
+		if len(requests) < len(ambulances) {
+			return fmt.Errorf("incident %d only has %d pending ambulance requests", incidentId, len(requests))
+		}
+
+		for i, ambulance := range ambulances {
+			request := requests[i]
+
+			var sendable int64
+			err = tx.Table("ambulances").
+				Joins("INNER JOIN ambulance_requests ON ambulance_requests.request_id = ?", request.RequestID).
+				Where("ambulances.ambulance_id = ?", ambulance.AmbulanceID).
+				Where(withoutImminentMaintenance, db.config.LongDistanceThreshold, db.config.MaintenanceLookahead.Seconds()).
+				Count(&sendable).Error
+			if err != nil {
+				return err
+			}
+			if sendable == 0 {
+				return fmt.Errorf("ambulance %s is due for maintenance and cannot be sent as far as request %d", ambulance.AmbulanceNumber, request.RequestID)
+			}
+
+			err = tx.Table("ambulance_requests").
+				Where("request_id = ?", request.RequestID).
+				Updates(map[string]interface{}{
+					"ambulance_id":  ambulance.AmbulanceID,
+					"status":        schema.ReqAccepted,
+					"claimed_by":    nil,
+					"claimed_until": nil,
+					"updated_at":    time.Now(),
+				}).Error
+			if err != nil {
+				return err
+			}
+
+			err = tx.Table("ambulances").
+				Where("ambulance_id = ?", ambulance.AmbulanceID).
+				Update("status", schema.OnCall).Error
+			if err != nil {
+				return err
+			}
+
+			ambulanceId, requestId := ambulance.AmbulanceID, request.RequestID
+			err = recordIncidentEvent(tx, schema.IncidentEvent{
+				IncidentID:  incidentId,
+				EventType:   schema.IncidentAmbulanceAssigned,
+				Description: fmt.Sprintf("ambulance %s assigned to request %d", ambulance.AmbulanceNumber, requestId),
+				CallID:      &request.EmergencyCallID,
+				RequestID:   &requestId,
+				AmbulanceID: &ambulanceId,
+				RecordedBy:  allocatedBy,
+			})
+			if err != nil {
+				return err
+			}
+
+			allocations = append(allocations, IncidentAllocation{
+				RequestID:   requestId,
+				AmbulanceID: ambulanceId,
+				HospitalID:  request.HospitalID,
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return allocations, nil
+}
+
+func (db *KwikMedicalDBClient) GetIncidentCasualtySummary(incidentId uint) ([]HospitalCasualtySummary, error) {
+	var summaries []HospitalCasualtySummary
+
+	// each call's latest request decides where its casualty is going
+	err := db.gormDb.Raw(`
+	WITH casualties AS (
+		SELECT DISTINCT ON (coalesce(emergency_calls.patient_id::text, 'call-' || emergency_calls.call_id))
+			coalesce(latest.hospital_id, 0) AS hospital_id,
+			emergency_calls.severity
+		FROM emergency_calls
+		LEFT JOIN LATERAL (
+			SELECT hospital_id FROM ambulance_requests
+			WHERE ambulance_requests.emergency_call_id = emergency_calls.call_id
+			ORDER BY created_at DESC
+			LIMIT 1
+		) latest ON true
+		WHERE emergency_calls.incident_id = ?
+		ORDER BY coalesce(emergency_calls.patient_id::text, 'call-' || emergency_calls.call_id), emergency_calls.call_time DESC
+	)
+	SELECT casualties.hospital_id,
+		coalesce(regional_hospitals.name, '') AS hospital_name,
+		count(*) AS casualties,
+		count(*) FILTER (WHERE severity = 'CRITICAL') AS critical,
+		count(*) FILTER (WHERE severity = 'HIGH') AS high,
+		count(*) FILTER (WHERE severity = 'MODERATE') AS moderate,
+		count(*) FILTER (WHERE severity = 'LOW') AS low,
+		count(*) FILTER (WHERE severity IS NULL OR severity = 'UNKNOWN_INJURY_SEVERITY') AS unknown
+	FROM casualties
+	LEFT JOIN regional_hospitals ON regional_hospitals.hospital_id = casualties.hospital_id
+	GROUP BY casualties.hospital_id, regional_hospitals.name
+	ORDER BY casualties.hospital_id`, incidentId).
+		Scan(&summaries).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return summaries, nil
+}
+
+func (db *KwikMedicalDBClient) StandDownIncident(incidentId uint, stoodDownBy string) error {
+	return db.DbTransaction(func(tx *gorm.DB) error {
+		if _, err := lockOpenIncident(tx, incidentId); err != nil {
+			return err
+		}
+
+		err := tx.Model(&schema.Incident{}).
+			Where("incident_id = ?", incidentId).
+			Updates(map[string]interface{}{
+				"status":        schema.IncidentStoodDown,
+				"stood_down_at": time.Now(),
+			}).Error
+		if err != nil {
+			return err
+		}
+
+		return recordIncidentEvent(tx, schema.IncidentEvent{
+			IncidentID:  incidentId,
+			EventType:   schema.IncidentStoodDownEvent,
+			Description: "incident stood down",
+			RecordedBy:  stoodDownBy,
+		})
+	})
+}
+
+func lockOpenIncident(tx *gorm.DB, incidentId uint) (*schema.Incident, error) {
+	var incident schema.Incident
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&incident, incidentId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("no incident found with id %d", incidentId)
+		}
+		return nil, err
+	}
+	if incident.Status == schema.IncidentStoodDown {
+		return nil, fmt.Errorf("incident %d has been stood down", incidentId)
+	}
+
+	return &incident, nil
+}
+
+func recordIncidentEvent(tx *gorm.DB, event schema.IncidentEvent) error {
+	return tx.Create(&event).Error
+}
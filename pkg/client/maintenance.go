@@ -0,0 +1,298 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/config"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/schema"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"time"
+)
+
+// pendingMaintenance are the statuses of windows that have not started yet
+var pendingMaintenance = []schema.MaintenanceStatus{schema.MaintenanceScheduled, schema.MaintenanceDeferred}
+
+// withoutImminentMaintenance excludes ambulances that are due for maintenance within the lookahead from requests
+// further away than the long-distance threshold, so they are not sent on calls they would not be back from in
+// time. It takes the threshold in metres and the lookahead in seconds, and expects ambulances joined to
+// ambulance_requests. Ambulances or requests without a location, which are stored as 0, 0 when unset, are never
+// long-distance.
+const withoutImminentMaintenance = `NOT (
+	coalesce(location_distance(
+		NULLIF(ambulances.current_location, '{"latitude": 0, "longitude": 0}'),
+		NULLIF(ambulance_requests.location, '{"latitude": 0, "longitude": 0}')
+	), 0) > ?
+	AND EXISTS (
+		SELECT 1 FROM maintenance_windows
+		WHERE maintenance_windows.ambulance_id = ambulances.ambulance_id
+			AND maintenance_windows.status IN ('SCHEDULED', 'DEFERRED')
+			AND maintenance_windows.planned_start <= CURRENT_TIMESTAMP + ? * INTERVAL '1 second'
+	)
+)`
+
+func (db *KwikMedicalDBClient) ScheduleMaintenance(ambulanceId uint, plannedStart time.Time, plannedEnd time.Time, reason string, odometer *int) (*schema.MaintenanceWindow, error) {
+	if !plannedEnd.After(plannedStart) {
+		return nil, errors.New("maintenance must end after it starts")
+	}
+	if odometer != nil && *odometer < 0 {
+		return nil, errors.New("odometer reading cannot be negative")
+	}
+
+	window := schema.MaintenanceWindow{
+		AmbulanceID:  ambulanceId,
+		PlannedStart: plannedStart,
+		PlannedEnd:   plannedEnd,
+		Reason:       strings.TrimSpace(reason),
+		Odometer:     odometer,
+		Status:       schema.MaintenanceScheduled,
+	}
+
+	err := db.DbTransaction(func(tx *gorm.DB) error {
+		if _, err := getAmbulance(tx.Clauses(clause.Locking{Strength: "UPDATE"}), ambulanceId); err != nil {
+			return err
+		}
+
+		var overlapping []uint
+		err := tx.Table("maintenance_windows").
+			Where("ambulance_id = ?", ambulanceId).
+			Where("status IN ?", []schema.MaintenanceStatus{
+				schema.MaintenanceScheduled, schema.MaintenanceDeferred, schema.MaintenanceInProgress,
+			}).
+			Where("planned_start < ? AND planned_end > ?", plannedEnd, plannedStart).
+			Pluck("window_id", &overlapping).Error
+		if err != nil {
+			return err
+		}
+		if len(overlapping) > 0 {
+			return fmt.Errorf("ambulance %d already has maintenance window %d planned in that time", ambulanceId, overlapping[0])
+		}
+
+		return tx.Create(&window).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &window, nil
+}
+
+func (db *KwikMedicalDBClient) GetMaintenanceWindows(ambulanceId uint) ([]schema.MaintenanceWindow, error) {
+	var windows []schema.MaintenanceWindow
+
+	err := db.gormDb.Where("ambulance_id = ?", ambulanceId).
+		Order("planned_start DESC").
+		Find(&windows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return windows, nil
+}
+
+func (db *KwikMedicalDBClient) CancelMaintenance(windowId uint) error {
+	return db.DbTransaction(func(tx *gorm.DB) error {
+		window, err := lockMaintenanceWindow(tx, windowId)
+		if err != nil {
+			return err
+		}
+		if window.Status != schema.MaintenanceScheduled && window.Status != schema.MaintenanceDeferred {
+			return fmt.Errorf("maintenance window %d is %s and cannot be cancelled", windowId, window.Status)
+		}
+
+		return tx.Model(window).Update("status", schema.MaintenanceCancelled).Error
+	})
+}
+
+// StartDueMaintenance takes the ambulances of windows that have fallen due out of service. Ambulances that are
+// on a call keep it and their windows are deferred, to be started once they are free. The windows started are
+// returned.
+func (db *KwikMedicalDBClient) StartDueMaintenance() ([]schema.MaintenanceWindow, error) {
+	var started []schema.MaintenanceWindow
+
+	err := db.DbTransaction(func(tx *gorm.DB) error {
+		var due []schema.MaintenanceWindow
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status IN ?", pendingMaintenance).
+			Where("planned_start <= CURRENT_TIMESTAMP").
+			Order("planned_start ASC").
+			Find(&due).Error
+		if err != nil {
+			return err
+		}
+
+		for i := range due {
+			window := &due[i]
+			ambulance, err := getAmbulance(tx.Clauses(clause.Locking{Strength: "UPDATE"}), window.AmbulanceID)
+			if err != nil {
+				return err
+			}
+
+			if ambulance.Status == schema.OnCall {
+				if window.Status != schema.MaintenanceDeferred {
+					if err = tx.Model(window).Update("status", schema.MaintenanceDeferred).Error; err != nil {
+						return err
+					}
+					db.logger.Info("Deferred maintenance of ambulance on a call",
+						zap.Uint("window_id", window.WindowID), zap.Uint("ambulance_id", window.AmbulanceID))
+				}
+				continue
+			}
+
+			if err = startMaintenance(tx, window); err != nil {
+				return err
+			}
+			started = append(started, *window)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return started, nil
+}
+
+// CompleteMaintenance finishes an in-progress window, recording the odometer reading when one is given, and
+// returns the ambulance to service unless another window is still in progress for it or it has no crew.
+func (db *KwikMedicalDBClient) CompleteMaintenance(windowId uint, odometer *int) error {
+	if odometer != nil && *odometer < 0 {
+		return errors.New("odometer reading cannot be negative")
+	}
+
+	return db.DbTransaction(func(tx *gorm.DB) error {
+		window, err := lockMaintenanceWindow(tx, windowId)
+		if err != nil {
+			return err
+		}
+		if window.Status != schema.MaintenanceInProgress {
+			return fmt.Errorf("maintenance window %d is %s, not in progress", windowId, window.Status)
+		}
+
+		updates := map[string]interface{}{
+			"status":       schema.MaintenanceCompleted,
+			"completed_at": gorm.Expr("CURRENT_TIMESTAMP"),
+		}
+		if odometer != nil {
+			updates["odometer"] = *odometer
+		}
+		if err = tx.Model(window).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		var inProgress int64
+		err = tx.Table("maintenance_windows").
+			Where("ambulance_id = ? AND status = ?", window.AmbulanceID, schema.MaintenanceInProgress).
+			Count(&inProgress).Error
+		if err != nil || inProgress > 0 {
+			return err
+		}
+
+		// an ambulance that lost its crew while in maintenance is out of service until it has one again
+		status := schema.Available
+		if err = requireCrew(tx, window.AmbulanceID); errors.Is(err, ErrIncompleteCrew) {
+			db.logger.Warn("Ambulance has no crew after maintenance", zap.Uint("ambulance_id", window.AmbulanceID))
+			status = schema.UnknownAmbulance
+		} else if err != nil {
+			return err
+		}
+
+		return tx.Table("ambulances").
+			Where("ambulance_id = ? AND status = ?", window.AmbulanceID, schema.Maintenance).
+			Update("status", status).Error
+	})
+}
+
+// WatchMaintenance starts windows as they fall due at the configured interval until the context is cancelled
+func (db *KwikMedicalDBClient) WatchMaintenance(ctx context.Context) {
+	interval := db.config.MaintenanceWatchInterval
+	if interval <= 0 {
+		interval = config.MaintenanceWatchIntervalDefault
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		started, err := db.StartDueMaintenance()
+		if err != nil {
+			db.logger.Error("Failed to start due maintenance", zap.Error(err))
+		}
+		for _, window := range started {
+			db.logger.Info("Started maintenance",
+				zap.Uint("window_id", window.WindowID), zap.Uint("ambulance_id", window.AmbulanceID))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// releaseFromCall takes an ambulance coming off a call into maintenance when one of its windows has fallen due,
+// including any deferred while it was on the call, and otherwise makes it available again
+func (db *KwikMedicalDBClient) releaseFromCall(tx *gorm.DB, ambulanceId uint) error {
+	// windows the maintenance watcher has locked are skipped rather than waited on, as it goes on to lock the
+	// ambulance held here. It starts them itself once the ambulance is available.
+	var window schema.MaintenanceWindow
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("ambulance_id = ?", ambulanceId).
+		Where("status IN ?", pendingMaintenance).
+		Where("planned_start <= CURRENT_TIMESTAMP").
+		Order("planned_start ASC").
+		Limit(1).
+		Find(&window).Error
+	if err != nil {
+		return err
+	}
+
+	if window.WindowID == 0 {
+		return tx.Table("ambulances").
+			Where("ambulance_id = ?", ambulanceId).
+			Update("status", schema.Available).Error
+	}
+
+	if err = startMaintenance(tx, &window); err != nil {
+		return err
+	}
+	db.logger.Info("Started maintenance of ambulance back from a call",
+		zap.Uint("window_id", window.WindowID), zap.Uint("ambulance_id", ambulanceId))
+	return nil
+}
+
+// startMaintenance puts a window in progress and takes its ambulance out of service, reloading the window
+func startMaintenance(tx *gorm.DB, window *schema.MaintenanceWindow) error {
+	err := tx.Model(window).Updates(map[string]interface{}{
+		"status":     schema.MaintenanceInProgress,
+		"started_at": gorm.Expr("CURRENT_TIMESTAMP"),
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	err = tx.Table("ambulances").
+		Where("ambulance_id = ?", window.AmbulanceID).
+		Update("status", schema.Maintenance).Error
+	if err != nil {
+		return err
+	}
+
+	return tx.First(window, window.WindowID).Error
+}
+
+func lockMaintenanceWindow(tx *gorm.DB, windowId uint) (*schema.MaintenanceWindow, error) {
+	var window schema.MaintenanceWindow
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&window, windowId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("no maintenance window found with id %d", windowId)
+		}
+		return nil, err
+	}
+	return &window, nil
+}
@@ -2,22 +2,29 @@ package client
 
 import (
 	"errors"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/alerts"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/nhs"
 	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/schema"
+	"github.com/jamieyoung5/kwikmedical-eventstream/pb"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
 )
 
 type EmergencyCallPatientInfo struct {
-	FirstName string
-	LastName  string
-	Address   string
+	NHSNumber   string
+	FirstName   string
+	LastName    string
+	Address     string
+	DateOfBirth string
+	PhoneNumber string
 }
 
 type HistoricalPatientData struct {
 	Patient       *schema.Patient
 	MedicalRecord *schema.MedicalRecord
 	Callouts      []schema.CallOutDetails
+	// Alerts are ordered by priority, most urgent first, for prominent display to the crew
+	Alerts []alerts.Alert
 }
 
 func (db *KwikMedicalDBClient) GetHistoricalPatientDataByID(id uint) (HistoricalPatientData, error) {
@@ -33,10 +40,17 @@ func (db *KwikMedicalDBClient) GetHistoricalPatientDataByID(id uint) (Historical
 		return HistoricalPatientData{Patient: patient}, err
 	}
 
+	entries, err := db.GetClinicalEntries(id)
+	if err != nil {
+		db.logger.Error("Unable to get clinical entries", zap.Int("id", int(id)), zap.Error(err))
+		return HistoricalPatientData{Patient: patient, MedicalRecord: medicalRecord, Callouts: callouts}, err
+	}
+
 	return HistoricalPatientData{
 		Patient:       patient,
 		MedicalRecord: medicalRecord,
 		Callouts:      callouts,
+		Alerts:        db.alertRules.Evaluate(medicalRecord, entries),
 	}, nil
 }
 
@@ -60,45 +74,62 @@ func (db *KwikMedicalDBClient) GetPatientByID(id uint) (*schema.Patient, error)
 	return &patient, nil
 }
 
-func (db *KwikMedicalDBClient) FindClosestPatientID(callInfo EmergencyCallPatientInfo) (uint, error) {
-	var patient schema.Patient
+func (db *KwikMedicalDBClient) GetPatientByNHSNumber(nhsNumber string) (*schema.Patient, error) {
+	normalized, err := nhs.Validate(nhsNumber)
+	if err != nil {
+		return nil, err
+	}
 
-	// construct search clause
-	var searchClause []clause.Expression
-	if callInfo.FirstName != "" {
-		searchClause = append(searchClause, clause.Eq{
-			Column: clause.Column{Name: "first_name"},
-			Value:  callInfo.FirstName,
-		})
+	var patient schema.Patient
+	if err = db.gormDb.Where("nhs_number = ?", normalized).First(&patient).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("patient not found")
+		}
+		return nil, err
 	}
-	if callInfo.LastName != "" {
-		searchClause = append(searchClause, clause.Eq{
-			Column: clause.Column{Name: "last_name"},
-			Value:  callInfo.LastName,
-		})
+
+	return &patient, nil
+}
+
+func (db *KwikMedicalDBClient) CreatePatient(patient *pb.Patient) (int32, error) {
+	newPatient, err := schema.PatientPbToGorm(patient)
+	if err != nil {
+		return 0, err
 	}
-	if callInfo.Address != "" {
-		searchClause = append(searchClause, clause.Eq{
-			Column: clause.Column{Name: "address"},
-			Value:  callInfo.Address,
-		})
+
+	if err = db.gormDb.Create(&newPatient).Error; err != nil {
+		return 0, err
 	}
 
-	// tries combinations of name and address to find the best patient match
-	err := db.DbTransaction(func(tx *gorm.DB) error {
-		if err := tx.Clauses(searchClause...).
-			First(&patient).Error; err == nil {
-			return nil
-		}
+	return int32(newPatient.PatientID), nil
+}
 
-		return errors.New("patient not found")
-	})
+func (db *KwikMedicalDBClient) FindClosestPatientID(callInfo EmergencyCallPatientInfo) (uint, error) {
+	// an NHS number uniquely identifies a patient, so it always wins over name and address matching
+	if callInfo.NHSNumber != "" {
+		match, err := db.GetPatientByNHSNumber(callInfo.NHSNumber)
+		if err == nil {
+			return match.PatientID, nil
+		}
+		db.logger.Debug("Unable to match patient by NHS number, falling back to name and address", zap.Error(err))
+	}
 
+	// rank patients on name, address, date of birth and phone number and only link confident matches
+	matches, err := db.FindPatientCandidates(callInfo)
 	if err != nil {
 		return 0, err
 	}
 
-	return patient.PatientID, nil
+	if len(matches) == 0 || matches[0].Confidence < db.patientMatchThreshold() {
+		return 0, errors.New("patient not found")
+	}
+
+	db.logger.Debug("Matched patient",
+		zap.Uint("id", matches[0].Patient.PatientID),
+		zap.Float64("confidence", matches[0].Confidence),
+		zap.Strings("matched fields", matches[0].MatchedFields))
+
+	return matches[0].Patient.PatientID, nil
 }
 
 func (db *KwikMedicalDBClient) GetPatientByEmergencyCall(callId uint) (uint, error) {
@@ -0,0 +1,254 @@
+package client
+
+import (
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/config"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/schema"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MatchedFirstName   = "first_name"
+	MatchedLastName    = "last_name"
+	MatchedAddress     = "address"
+	MatchedDateOfBirth = "date_of_birth"
+	MatchedPhoneNumber = "phone_number"
+
+	// fieldMatchScore is the per-field similarity above which a field is reported as matched
+	fieldMatchScore = 0.6
+	// candidateScore is the per-field similarity a patient must reach on at least one field to be considered
+	candidateScore = 0.3
+)
+
+// matchWeights controls how much each field contributes to a candidate's confidence. Only the fields
+// present on the call are weighed, so a call with just a name and a date of birth can still reach 1.0.
+var matchWeights = map[string]float64{
+	MatchedFirstName:   0.2,
+	MatchedLastName:    0.3,
+	MatchedAddress:     0.15,
+	MatchedDateOfBirth: 0.25,
+	MatchedPhoneNumber: 0.1,
+}
+
+var nonDigits = regexp.MustCompile(`\D`)
+
+// normalizedPhoneNumber is normalizePhoneNumber in SQL, matching the expression the phone number index is on
+const normalizedPhoneNumber = "right(regexp_replace(coalesce(phone_number, ''), '\\D', '', 'g'), 10)"
+
+// dateOfBirthLayouts are the formats a date of birth given on a call is read in
+var dateOfBirthLayouts = []string{"2006-01-02", "02/01/2006"}
+
+type PatientMatch struct {
+	Patient       *schema.Patient
+	Confidence    float64
+	MatchedFields []string
+}
+
+// patientMatchRow holds a candidate patient and how closely each field matched, fields that could not
+// be compared (e.g. not given on the call) are left nil and do not count towards the confidence
+type patientMatchRow struct {
+	schema.Patient
+	FirstNameScore   *float64
+	LastNameScore    *float64
+	AddressScore     *float64
+	DateOfBirthScore *float64
+	PhoneNumberScore *float64
+}
+
+func (row *patientMatchRow) scores() map[string]*float64 {
+	return map[string]*float64{
+		MatchedFirstName:   row.FirstNameScore,
+		MatchedLastName:    row.LastNameScore,
+		MatchedAddress:     row.AddressScore,
+		MatchedDateOfBirth: row.DateOfBirthScore,
+		MatchedPhoneNumber: row.PhoneNumberScore,
+	}
+}
+
+// FindPatientCandidates ranks patients against the details given on an emergency call using trigram
+// similarity and phonetic (Double Metaphone/Soundex) matching on names, trigram word similarity on
+// the address and exact matching on date of birth and phone number. At most the configured number
+// of candidates are returned, best first.
+func (db *KwikMedicalDBClient) FindPatientCandidates(callInfo EmergencyCallPatientInfo) ([]PatientMatch, error) {
+	args := map[string]interface{}{
+		"first_name":    strings.TrimSpace(callInfo.FirstName),
+		"last_name":     strings.TrimSpace(callInfo.LastName),
+		"address":       strings.TrimSpace(callInfo.Address),
+		"date_of_birth": parseDateOfBirth(callInfo.DateOfBirth),
+		"phone_number":  normalizePhoneNumber(callInfo.PhoneNumber),
+		"min_score":     candidateScore,
+	}
+
+	// construct a score expression for every field given on the call, unscored fields stay NULL
+	expressions := map[string]string{
+		MatchedFirstName:   "NULL",
+		MatchedLastName:    "NULL",
+		MatchedAddress:     "NULL",
+		MatchedDateOfBirth: "NULL",
+		MatchedPhoneNumber: "NULL",
+	}
+	// candidates narrows the patients scored to those likely to match on a given field using its index,
+	// trigram conditions use pg_trgm's similarity thresholds
+	var candidates []string
+	if args["first_name"] != "" {
+		expressions[MatchedFirstName] = phoneticNameScore("first_name", "@first_name")
+		candidates = append(candidates, phoneticNameCandidate("first_name", "@first_name"))
+	}
+	if args["last_name"] != "" {
+		expressions[MatchedLastName] = phoneticNameScore("last_name", "@last_name")
+		candidates = append(candidates, phoneticNameCandidate("last_name", "@last_name"))
+	}
+	if args["address"] != "" {
+		expressions[MatchedAddress] = "word_similarity(lower(@address), lower(coalesce(address, '')))"
+		candidates = append(candidates, "lower(@address) <% lower(address)")
+	}
+	if args["date_of_birth"] != "" {
+		expressions[MatchedDateOfBirth] = "CASE WHEN date_of_birth = CAST(@date_of_birth AS date) THEN 1 ELSE 0 END"
+		candidates = append(candidates, "date_of_birth = CAST(@date_of_birth AS date)")
+	}
+	if args["phone_number"] != "" {
+		expressions[MatchedPhoneNumber] = "CASE WHEN " + normalizedPhoneNumber + " = @phone_number THEN 1 ELSE 0 END"
+		candidates = append(candidates, normalizedPhoneNumber+" = @phone_number")
+	}
+
+	var filters, ranking []string
+	for field, expression := range expressions {
+		if expression == "NULL" {
+			continue
+		}
+		filters = append(filters, field+"_score >= @min_score")
+		ranking = append(ranking, field+"_score * "+formatWeight(matchWeights[field]))
+	}
+	if len(filters) == 0 {
+		return nil, nil
+	}
+
+	args["limit"] = db.patientMatchLimit()
+
+	query := `
+	SELECT * FROM (
+		SELECT patients.*,
+			` + expressions[MatchedFirstName] + ` AS first_name_score,
+			` + expressions[MatchedLastName] + ` AS last_name_score,
+			` + expressions[MatchedAddress] + ` AS address_score,
+			` + expressions[MatchedDateOfBirth] + ` AS date_of_birth_score,
+			` + expressions[MatchedPhoneNumber] + ` AS phone_number_score
+		FROM patients
+		WHERE ` + strings.Join(candidates, " OR ") + `
+	) scored
+	WHERE ` + strings.Join(filters, " OR ") + `
+	ORDER BY ` + strings.Join(ranking, " + ") + ` DESC
+	LIMIT @limit
+`
+
+	var rows []patientMatchRow
+	if err := db.gormDb.Raw(query, args).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	matches := make([]PatientMatch, 0, len(rows))
+	for i := range rows {
+		patient := rows[i].Patient
+		confidence, matchedFields := scorePatientFields(rows[i].scores())
+		matches = append(matches, PatientMatch{
+			Patient:       &patient,
+			Confidence:    confidence,
+			MatchedFields: matchedFields,
+		})
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Confidence > matches[j].Confidence
+	})
+
+	return matches, nil
+}
+
+// scorePatientFields weighs the compared fields into an overall confidence between 0 and 1 and
+// reports which of them matched closely enough to show to a call handler.
+func scorePatientFields(scores map[string]*float64) (float64, []string) {
+	var (
+		total, weights float64
+		matchedFields  []string
+	)
+	for field, score := range scores {
+		if score == nil {
+			continue
+		}
+
+		total += *score * matchWeights[field]
+		weights += matchWeights[field]
+		if *score >= fieldMatchScore {
+			matchedFields = append(matchedFields, field)
+		}
+	}
+	sort.Strings(matchedFields)
+
+	if weights == 0 {
+		return 0, matchedFields
+	}
+
+	return total / weights, matchedFields
+}
+
+// phoneticNameCandidate matches names that are similar or sound alike using the trigram and Double
+// Metaphone indexes, names that only share a Soundex code are not considered
+func phoneticNameCandidate(left, right string) string {
+	return "lower(" + left + ") % lower(" + right + ") OR dmetaphone(" + left + ") = dmetaphone(" + right + ")"
+}
+
+// phoneticNameScore scores a name column by the best of its trigram similarity and phonetic equality,
+// so that both typos ("Jhon") and sound-alike spellings ("Smyth") are caught.
+func phoneticNameScore(left, right string) string {
+	return `GREATEST(
+				similarity(lower(` + left + `), lower(` + right + `)),
+				CASE
+					WHEN dmetaphone(` + left + `) = dmetaphone(` + right + `) THEN 0.9
+					WHEN soundex(` + left + `) = soundex(` + right + `) THEN 0.8
+					ELSE 0
+				END
+			)`
+}
+
+// normalizePhoneNumber keeps the last ten digits so "+44 7700 900123" and "07700 900123" compare equal
+func normalizePhoneNumber(phoneNumber string) string {
+	digits := nonDigits.ReplaceAllString(phoneNumber, "")
+	if len(digits) > 10 {
+		digits = digits[len(digits)-10:]
+	}
+	return digits
+}
+
+func formatWeight(weight float64) string {
+	return strconv.FormatFloat(weight, 'f', -1, 64)
+}
+
+// parseDateOfBirth returns the date of birth as an ISO date, or an empty string when it cannot be read so that it
+// is left out of the match rather than failing it
+func parseDateOfBirth(dateOfBirth string) string {
+	dateOfBirth = strings.TrimSpace(dateOfBirth)
+	for _, layout := range dateOfBirthLayouts {
+		if date, err := time.Parse(layout, dateOfBirth); err == nil {
+			return date.Format("2006-01-02")
+		}
+	}
+	return ""
+}
+
+// patientMatchThreshold and patientMatchLimit fall back to the defaults for configs built without them
+func (db *KwikMedicalDBClient) patientMatchThreshold() float64 {
+	if db.config.PatientMatchThreshold == 0 {
+		return config.PatientMatchThresholdDefault
+	}
+	return db.config.PatientMatchThreshold
+}
+
+func (db *KwikMedicalDBClient) patientMatchLimit() int {
+	if db.config.PatientMatchLimit <= 0 {
+		return config.PatientMatchLimitDefault
+	}
+	return db.config.PatientMatchLimit
+}
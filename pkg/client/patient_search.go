@@ -0,0 +1,193 @@
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/schema"
+	"github.com/jamieyoung5/kwikmedical-eventstream/pb"
+	"strings"
+	"time"
+)
+
+type PatientSortOrder string
+
+const (
+	SortPatientsByName      PatientSortOrder = "name"
+	SortPatientsByCreatedAt PatientSortOrder = "created_at"
+	SortPatientsByID        PatientSortOrder = "patient_id"
+
+	defaultPageSize = 25
+	maxPageSize     = 100
+)
+
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// PatientSearchFilter narrows a patient search, zero values are ignored.
+type PatientSearchFilter struct {
+	// NamePrefix matches the start of the first name, the last name or the full name
+	NamePrefix string
+	// Postcode matches a postcode anywhere in the address, ignoring case and spacing
+	Postcode      string
+	BornAfter     *time.Time
+	BornBefore    *time.Time
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	HasAllergy    string
+	SortOrder     PatientSortOrder
+}
+
+type PageRequest struct {
+	Size  int
+	Token string
+}
+
+// patientCursor is the keyset position of the last patient on a page, it is handed to clients as an opaque token
+type patientCursor struct {
+	SortOrder PatientSortOrder `json:"s"`
+	PatientID uint             `json:"id"`
+	LastName  string           `json:"ln,omitempty"`
+	FirstName string           `json:"fn,omitempty"`
+	CreatedAt time.Time        `json:"ca"`
+}
+
+// SearchPatients lists patients matching the filter using keyset pagination, so pages remain stable while
+// patients are being added. The returned token is empty once the last page has been reached.
+func (db *KwikMedicalDBClient) SearchPatients(filter PatientSearchFilter, page PageRequest) ([]*pb.Patient, string, error) {
+	sortOrder := filter.SortOrder
+	if sortOrder == "" {
+		sortOrder = SortPatientsByName
+	}
+
+	size := page.Size
+	if size <= 0 {
+		size = defaultPageSize
+	}
+	if size > maxPageSize {
+		size = maxPageSize
+	}
+
+	query := db.gormDb.Model(&schema.Patient{})
+
+	if filter.NamePrefix != "" {
+		prefix := escapeLike(strings.TrimSpace(filter.NamePrefix)) + "%"
+		query = query.Where(
+			"first_name ILIKE ? OR last_name ILIKE ? OR first_name || ' ' || last_name ILIKE ?",
+			prefix, prefix, prefix)
+	}
+	if filter.Postcode != "" {
+		postcode := escapeLike(strings.ToUpper(strings.ReplaceAll(filter.Postcode, " ", "")))
+		query = query.Where("upper(replace(address, ' ', '')) LIKE ?", "%"+postcode+"%")
+	}
+	if filter.BornAfter != nil {
+		query = query.Where("date_of_birth >= ?", *filter.BornAfter)
+	}
+	if filter.BornBefore != nil {
+		query = query.Where("date_of_birth <= ?", *filter.BornBefore)
+	}
+	if filter.CreatedAfter != nil {
+		query = query.Where("created_at >= ?", *filter.CreatedAfter)
+	}
+	if filter.CreatedBefore != nil {
+		query = query.Where("created_at <= ?", *filter.CreatedBefore)
+	}
+	if filter.HasAllergy != "" {
+		// matched on the structured substance, the free text projection carries the severity alongside it
+		query = query.Where(`EXISTS (
+			SELECT 1 FROM medical_records
+			INNER JOIN medical_allergies ON medical_allergies.record_id = medical_records.record_id
+			WHERE medical_records.patient_id = patients.patient_id AND lower(medical_allergies.substance) = lower(?)
+		)`, strings.TrimSpace(filter.HasAllergy))
+	}
+
+	if page.Token != "" {
+		cursor, err := decodePatientCursor(page.Token)
+		if err != nil {
+			return nil, "", err
+		}
+		if cursor.SortOrder != sortOrder {
+			return nil, "", fmt.Errorf("%w: token was issued for sort order %q", ErrInvalidPageToken, cursor.SortOrder)
+		}
+
+		switch sortOrder {
+		case SortPatientsByName:
+			query = query.Where("(last_name, first_name, patient_id) > (?, ?, ?)",
+				cursor.LastName, cursor.FirstName, cursor.PatientID)
+		case SortPatientsByCreatedAt:
+			query = query.Where("(coalesce(created_at, 'epoch'), patient_id) < (?, ?)",
+				cursor.CreatedAt, cursor.PatientID)
+		case SortPatientsByID:
+			query = query.Where("patient_id > ?", cursor.PatientID)
+		}
+	}
+
+	switch sortOrder {
+	case SortPatientsByName:
+		query = query.Order("last_name ASC, first_name ASC, patient_id ASC")
+	case SortPatientsByCreatedAt:
+		query = query.Order("coalesce(created_at, 'epoch') DESC, patient_id DESC")
+	case SortPatientsByID:
+		query = query.Order("patient_id ASC")
+	default:
+		return nil, "", fmt.Errorf("unknown patient sort order %q", sortOrder)
+	}
+
+	// fetch one extra row to find out whether there is another page
+	var patients []schema.Patient
+	if err := query.Limit(size + 1).Find(&patients).Error; err != nil {
+		return nil, "", err
+	}
+
+	var nextToken string
+	if len(patients) > size {
+		patients = patients[:size]
+
+		last := patients[size-1]
+		token, err := encodePatientCursor(patientCursor{
+			SortOrder: sortOrder,
+			PatientID: last.PatientID,
+			LastName:  last.LastName,
+			FirstName: last.FirstName,
+			CreatedAt: last.CreatedAt,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		nextToken = token
+	}
+
+	results := make([]*pb.Patient, len(patients))
+	for i := range patients {
+		results[i] = patients[i].ToPb()
+	}
+
+	return results, nextToken, nil
+}
+
+func encodePatientCursor(cursor patientCursor) (string, error) {
+	bytes, err := json.Marshal(cursor)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode page token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+func decodePatientCursor(token string) (patientCursor, error) {
+	var cursor patientCursor
+
+	bytes, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return cursor, fmt.Errorf("%w: %v", ErrInvalidPageToken, err)
+	}
+	if err = json.Unmarshal(bytes, &cursor); err != nil {
+		return cursor, fmt.Errorf("%w: %v", ErrInvalidPageToken, err)
+	}
+
+	return cursor, nil
+}
+
+// escapeLike escapes the LIKE wildcards so user input is matched literally
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
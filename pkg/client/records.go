@@ -6,26 +6,15 @@ import (
 	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/schema"
 	"github.com/jamieyoung5/kwikmedical-eventstream/pb"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
 )
 
 func (db *KwikMedicalDBClient) InsertNewCallout(callout *pb.CallOutDetail) error {
 	calloutDetails := schema.CalloutDetailPbToGorm(callout)
 
-	err := db.gormDb.Create(&calloutDetails).Error
-	if err != nil {
-		return err
-	}
-
-	patientId, err := db.GetPatientByEmergencyCall(calloutDetails.CallID)
-	err = db.gormDb.Exec(
-		`UPDATE medical_records SET callout_ids = array_append(callout_ids, ?) WHERE patient_id = ?`,
-		calloutDetails.DetailID,
-		patientId).Error
-	if err != nil {
-		return err
-	}
-
-	return nil
+	// callouts belong to a patient's medical record through their emergency call, so there is nothing else to update
+	return db.gormDb.Create(&calloutDetails).Error
 }
 
 func (db *KwikMedicalDBClient) GetMedicalRecordsByEmergencyCall(id uint) (*schema.MedicalRecord, []schema.CallOutDetails, error) {
@@ -57,19 +46,174 @@ func (db *KwikMedicalDBClient) GetMedicalRecordsByPatientID(id uint) (*schema.Me
 			return fmt.Errorf("no medical records found for patient ID %d", id)
 		}
 
-		if err := tx.Where("detail_id IN ?", []int64(medicalRecord.CalloutIDs)).Find(&callOutDetails).Error; err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				return fmt.Errorf("no callout details found for the given IDs")
+		var err error
+		callOutDetails, err = getCalloutsByPatientID(tx, id)
+		return err
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &medicalRecord, callOutDetails, nil
+}
+
+// getCalloutsByPatientID finds the patient's callouts through the emergency calls they were made for
+func getCalloutsByPatientID(tx *gorm.DB, patientId uint) ([]schema.CallOutDetails, error) {
+	var callOutDetails []schema.CallOutDetails
+
+	err := tx.Select("call_out_details.*").
+		Joins("INNER JOIN emergency_calls ON emergency_calls.call_id = call_out_details.call_id").
+		Where("emergency_calls.patient_id = ?", patientId).
+		Order("call_out_details.created_at ASC").
+		Find(&callOutDetails).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return callOutDetails, nil
+}
+
+type medicalRecordField string
+
+const (
+	conditionsField  medicalRecordField = "conditions"
+	medicationsField medicalRecordField = "medications"
+	allergiesField   medicalRecordField = "allergies"
+	notesField       medicalRecordField = "notes"
+)
+
+// AddCondition records a free text condition, see AddConditionEntry for coded conditions.
+func (db *KwikMedicalDBClient) AddCondition(patientId uint, condition string, changedBy string) error {
+	_, err := db.AddConditionEntry(patientId, schema.MedicalCondition{Description: condition}, changedBy)
+	return err
+}
+
+func (db *KwikMedicalDBClient) RemoveCondition(patientId uint, condition string, changedBy string) error {
+	return db.removeClinicalEntry(patientId, conditionsField, condition, changedBy)
+}
+
+// AddMedication records a free text medication, see AddMedicationEntry for coded medications.
+func (db *KwikMedicalDBClient) AddMedication(patientId uint, medication string, changedBy string) error {
+	_, err := db.AddMedicationEntry(patientId, schema.MedicalMedication{Name: medication}, changedBy)
+	return err
+}
+
+func (db *KwikMedicalDBClient) RemoveMedication(patientId uint, medication string, changedBy string) error {
+	return db.removeClinicalEntry(patientId, medicationsField, medication, changedBy)
+}
+
+// AddAllergy records a free text allergy, see AddAllergyEntry for coded allergies.
+func (db *KwikMedicalDBClient) AddAllergy(patientId uint, allergy string, changedBy string) error {
+	_, err := db.AddAllergyEntry(patientId, schema.MedicalAllergy{Substance: allergy}, changedBy)
+	return err
+}
+
+func (db *KwikMedicalDBClient) RemoveAllergy(patientId uint, allergy string, changedBy string) error {
+	return db.removeClinicalEntry(patientId, allergiesField, allergy, changedBy)
+}
+
+// AppendNote adds a note to the patient's medical record. The same note can be written more than once, so
+// retries are recognised by the caller's idempotency key rather than the text: a note is only appended once per
+// key. An empty key always appends.
+func (db *KwikMedicalDBClient) AppendNote(patientId uint, note string, idempotencyKey string, changedBy string) error {
+	note = strings.TrimSpace(note)
+	if note == "" {
+		return fmt.Errorf("cannot add an empty value to %s", notesField)
+	}
+
+	return db.DbTransaction(func(tx *gorm.DB) error {
+		recordId, err := beginMedicalRecordChange(tx, patientId, changedBy)
+		if err != nil {
+			return err
+		}
+
+		if idempotencyKey != "" {
+			result := tx.Exec(
+				`INSERT INTO medical_record_note_keys (patient_id, idempotency_key) VALUES (?, ?) ON CONFLICT DO NOTHING`,
+				patientId, idempotencyKey)
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
 			}
+		}
+
+		return tx.Exec(
+			`UPDATE medical_records
+			SET `+string(notesField)+` = array_append(coalesce(`+string(notesField)+`, '{}'), ?), last_updated = CURRENT_TIMESTAMP
+			WHERE record_id = ?`,
+			note, recordId).Error
+	})
+}
+
+func (db *KwikMedicalDBClient) RemoveNote(patientId uint, note string, changedBy string) error {
+	return db.DbTransaction(func(tx *gorm.DB) error {
+		recordId, err := beginMedicalRecordChange(tx, patientId, changedBy)
+		if err != nil {
 			return err
 		}
 
-		return nil
+		return removeFromRecordArray(tx, recordId, notesField, strings.TrimSpace(note))
 	})
+}
 
-	if err != nil {
-		return nil, nil, err
+// appendToRecordArray appends the value to one of the record's arrays unless it is already present. The update
+// is done in place by postgres on the locked row rather than read-modify-write, so concurrent edits to the same
+// array are never lost and last_updated is only bumped when something actually changed.
+func appendToRecordArray(tx *gorm.DB, recordId uint, field medicalRecordField, value string) error {
+	return tx.Exec(
+		`UPDATE medical_records
+		SET `+string(field)+` = array_append(coalesce(`+string(field)+`, '{}'), ?), last_updated = CURRENT_TIMESTAMP
+		WHERE record_id = ? AND NOT (? = ANY(coalesce(`+string(field)+`, '{}')))`,
+		value, recordId, value).Error
+}
+
+func removeFromRecordArray(tx *gorm.DB, recordId uint, field medicalRecordField, value string) error {
+	return tx.Exec(
+		`UPDATE medical_records
+		SET `+string(field)+` = array_remove(`+string(field)+`, ?), last_updated = CURRENT_TIMESTAMP
+		WHERE record_id = ? AND ? = ANY(`+string(field)+`)`,
+		value, recordId, value).Error
+}
+
+// beginMedicalRecordChange attributes the rest of the transaction to changedBy and locks the record being changed
+func beginMedicalRecordChange(tx *gorm.DB, patientId uint, changedBy string) (uint, error) {
+	if err := setChangedBy(tx, changedBy); err != nil {
+		return 0, err
 	}
 
-	return &medicalRecord, callOutDetails, nil
+	return lockLatestMedicalRecord(tx, patientId)
+}
+
+// lockLatestMedicalRecord locks the patient's current medical record for the rest of the transaction, creating
+// an empty one if the patient has none yet. The patient row is locked first so that two concurrent first edits
+// cannot each create a record.
+func lockLatestMedicalRecord(tx *gorm.DB, patientId uint) (uint, error) {
+	var patient schema.Patient
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("patient_id").
+		First(&patient, patientId).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, errors.New("patient not found")
+		}
+		return 0, err
+	}
+
+	var medicalRecord schema.MedicalRecord
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("patient_id = ?", patientId).
+		Order("last_updated DESC").
+		First(&medicalRecord).Error
+	if err == nil {
+		return medicalRecord.RecordID, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+
+	medicalRecord = schema.MedicalRecord{PatientID: patientId}
+	if err = tx.Create(&medicalRecord).Error; err != nil {
+		return 0, err
+	}
+
+	return medicalRecord.RecordID, nil
 }
@@ -0,0 +1,239 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/config"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/schema"
+	"github.com/jamieyoung5/kwikmedical-eventstream/pb"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+var (
+	ErrShiftOverlap = errors.New("shift overlaps another shift")
+	ErrShiftRest    = errors.New("shift does not leave the minimum rest period")
+)
+
+// coverableRoles are the other crew roles staff are qualified to be rostered in. Paramedics hold the licence
+// needed to drive an ambulance, nobody else covers another role.
+var coverableRoles = map[schema.StaffRole][]schema.StaffRole{
+	schema.Paramedic: {schema.Driver},
+}
+
+type ShiftFilter struct {
+	StaffID     uint
+	AmbulanceID uint
+	// From and To match shifts that are under way at any point between them
+	From *time.Time
+	To   *time.Time
+}
+
+// AmbulanceCrew is the staff on shift on an ambulance, with the roles they are rostered in
+type AmbulanceCrew struct {
+	AmbulanceID uint
+	Crew        []*pb.AmbulanceStaff
+}
+
+// Crewed is whether the crew has at least one paramedic and one driver
+func (c AmbulanceCrew) Crewed() bool {
+	var paramedic, driver bool
+	for _, staff := range c.Crew {
+		paramedic = paramedic || staff.Role == pb.StaffRole_PARAMEDIC
+		driver = driver || staff.Role == pb.StaffRole_DRIVER
+	}
+	return paramedic && driver
+}
+
+// ScheduleShift rosters a member of staff onto an ambulance. The shift is in the staff member's own role unless
+// another they are qualified to cover is given, and cannot overlap their other shifts or start or end within the
+// minimum rest period of them.
+func (db *KwikMedicalDBClient) ScheduleShift(staffId uint, ambulanceId uint, role pb.StaffRole, startsAt time.Time, endsAt time.Time) (*schema.StaffShift, error) {
+	if !endsAt.After(startsAt) {
+		return nil, errors.New("a shift must end after it starts")
+	}
+
+	rest := db.config.ShiftMinimumRest
+	if rest < 0 {
+		rest = config.ShiftMinimumRestDefault
+	}
+
+	shift := schema.StaffShift{
+		StaffID:     staffId,
+		AmbulanceID: ambulanceId,
+		StartsAt:    startsAt,
+		EndsAt:      endsAt,
+	}
+
+	err := db.DbTransaction(func(tx *gorm.DB) error {
+		// locking the staff member serialises the conflict checks of their shifts
+		staff, err := lockStaff(tx, staffId)
+		if err != nil {
+			return err
+		}
+		if !staff.IsActive {
+			return fmt.Errorf("staff member %d is not active", staffId)
+		}
+
+		shift.Role = staff.Role
+		if role != pb.StaffRole_UNKNOWN_STAFF_ROLE {
+			if shift.Role, err = schema.StaffRoleFromPb(role); err != nil {
+				return err
+			}
+		}
+		if !isCrewRole(shift.Role) {
+			return fmt.Errorf("%s is not a role that can crew an ambulance", shift.Role)
+		}
+		if !canCover(staff.Role, shift.Role) {
+			return fmt.Errorf("staff member %d is %s and cannot be rostered as %s", staffId, staff.Role, shift.Role)
+		}
+
+		if _, err = getAmbulance(tx, ambulanceId); err != nil {
+			return err
+		}
+
+		var conflicts []schema.StaffShift
+		err = tx.Table("staff_shifts").
+			Where("staff_id = ?", staffId).
+			Where("starts_at < ? AND ends_at > ?", endsAt.Add(rest), startsAt.Add(-rest)).
+			Order("starts_at ASC").
+			Find(&conflicts).Error
+		if err != nil {
+			return err
+		}
+		for _, conflict := range conflicts {
+			if conflict.StartsAt.Before(endsAt) && conflict.EndsAt.After(startsAt) {
+				return fmt.Errorf("%w: staff member %d is on shift %d", ErrShiftOverlap, staffId, conflict.ShiftID)
+			}
+		}
+		if len(conflicts) > 0 {
+			return fmt.Errorf("%w of %s next to shift %d", ErrShiftRest, rest, conflicts[0].ShiftID)
+		}
+
+		return tx.Table("staff_shifts").Create(&shift).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &shift, nil
+}
+
+func (db *KwikMedicalDBClient) CancelShift(shiftId uint) error {
+	result := db.gormDb.Table("staff_shifts").
+		Where("shift_id = ?", shiftId).
+		Delete(&schema.StaffShift{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("no shift found with id %d", shiftId)
+	}
+	return nil
+}
+
+func (db *KwikMedicalDBClient) GetShifts(filter ShiftFilter) ([]schema.StaffShift, error) {
+	query := db.gormDb.Table("staff_shifts")
+
+	if filter.StaffID != 0 {
+		query = query.Where("staff_id = ?", filter.StaffID)
+	}
+	if filter.AmbulanceID != 0 {
+		query = query.Where("ambulance_id = ?", filter.AmbulanceID)
+	}
+	if filter.From != nil {
+		query = query.Where("ends_at > ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("starts_at < ?", *filter.To)
+	}
+
+	var shifts []schema.StaffShift
+	if err := query.Order("starts_at ASC, shift_id ASC").Find(&shifts).Error; err != nil {
+		return nil, err
+	}
+
+	return shifts, nil
+}
+
+// OnDutyCrew lists the active staff on shift at the given time, grouped by the ambulance they are crewing
+func (db *KwikMedicalDBClient) OnDutyCrew(at time.Time) ([]AmbulanceCrew, error) {
+	return getOnDutyCrew(db.gormDb, at, 0)
+}
+
+// onDutyShifts are the shifts under way at the given time of staff who are still active. It is the roster both
+// the crews reported and the ambulances dispatch considers crewed are read from.
+func onDutyShifts(tx *gorm.DB, at interface{}) *gorm.DB {
+	return tx.Table("staff_shifts").
+		Joins("INNER JOIN ambulance_staff ON ambulance_staff.staff_id = staff_shifts.staff_id").
+		Where("ambulance_staff.is_active").
+		Where("staff_shifts.starts_at <= ? AND staff_shifts.ends_at > ?", at, at)
+}
+
+// withOnDutyCrew excludes ambulances without a paramedic and a driver on shift right now, the same crew OnDutyCrew
+// reports as Crewed. It is a condition on ambulances taking the database to build its roster query from.
+func withOnDutyCrew(tx *gorm.DB) clause.Expr {
+	crewed := onDutyShifts(tx.Session(&gorm.Session{NewDB: true}), gorm.Expr("CURRENT_TIMESTAMP")).
+		Select("staff_shifts.ambulance_id").
+		Where("staff_shifts.role IN ?", []schema.StaffRole{schema.Paramedic, schema.Driver}).
+		Group("staff_shifts.ambulance_id").
+		Having("count(DISTINCT staff_shifts.role) = 2")
+
+	return gorm.Expr("ambulances.ambulance_id IN (?)", crewed)
+}
+
+// getOnDutyCrew reads the crews on shift at the given time, of every ambulance or of just the one given
+func getOnDutyCrew(tx *gorm.DB, at interface{}, ambulanceId uint) ([]AmbulanceCrew, error) {
+	var rows []struct {
+		schema.AmbulanceStaff
+		ShiftAmbulanceID uint
+		ShiftRole        schema.StaffRole
+	}
+
+	query := onDutyShifts(tx, at).
+		Select("ambulance_staff.*, staff_shifts.ambulance_id AS shift_ambulance_id, staff_shifts.role AS shift_role")
+	if ambulanceId != 0 {
+		query = query.Where("staff_shifts.ambulance_id = ?", ambulanceId)
+	}
+	err := query.
+		Order("staff_shifts.ambulance_id ASC, staff_shifts.role ASC, ambulance_staff.last_name ASC").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var crews []AmbulanceCrew
+	for _, row := range rows {
+		if len(crews) == 0 || crews[len(crews)-1].AmbulanceID != row.ShiftAmbulanceID {
+			crews = append(crews, AmbulanceCrew{AmbulanceID: row.ShiftAmbulanceID})
+		}
+
+		staff := row.AmbulanceStaff
+		staff.AmbulanceID = &row.ShiftAmbulanceID
+		staff.Role = row.ShiftRole
+
+		staffPb, err := staff.ToPb()
+		if err != nil {
+			return nil, err
+		}
+
+		crew := &crews[len(crews)-1]
+		crew.Crew = append(crew.Crew, staffPb)
+	}
+
+	return crews, nil
+}
+
+// canCover is whether staff in the given role are qualified to be rostered in the other
+func canCover(role schema.StaffRole, other schema.StaffRole) bool {
+	if role == other {
+		return true
+	}
+	for _, coverable := range coverableRoles[role] {
+		if coverable == other {
+			return true
+		}
+	}
+	return false
+}
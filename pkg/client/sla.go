@@ -0,0 +1,276 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/config"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/schema"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"time"
+)
+
+// SLADefinition is the response target for calls of a severity, measured from the call being made to the
+// first ambulance arriving on scene
+type SLADefinition struct {
+	Severity       schema.InjurySeverity
+	ResponseTarget time.Duration
+}
+
+// CallTimings are the recorded milestones of an emergency call, nil until reached
+type CallTimings struct {
+	CallID           uint
+	Severity         schema.InjurySeverity
+	ResponseTarget   time.Duration
+	CallTime         time.Time
+	RequestCreatedAt *time.Time
+	AcceptedAt       *time.Time
+	ArrivedAt        *time.Time
+	CompletedAt      *time.Time
+	ResponseTime     *time.Duration
+	Breached         *bool
+}
+
+// SLABreach is a call that missed its response target, either because the ambulance arrived late or because
+// it is still waiting past the target
+type SLABreach struct {
+	CallID         uint
+	Severity       schema.InjurySeverity
+	CallTime       time.Time
+	ResponseTarget time.Duration
+	// ResponseTime is how long the call has waited so far when the ambulance has not arrived yet
+	ResponseTime time.Duration
+	Arrived      bool
+}
+
+func (b SLABreach) Overdue() time.Duration {
+	return b.ResponseTime - b.ResponseTarget
+}
+
+// SLAWarning is a pending call that is about to breach, or has already breached, its response target
+type SLAWarning struct {
+	CallID    uint
+	Severity  schema.InjurySeverity
+	CallTime  time.Time
+	Deadline  time.Time
+	Remaining time.Duration
+}
+
+type callTimingsRow struct {
+	CallID           uint
+	Severity         schema.InjurySeverity
+	ResponseTarget   *schema.Interval
+	CallTime         time.Time
+	RequestCreatedAt *time.Time
+	AcceptedAt       *time.Time
+	ArrivedAt        *time.Time
+	CompletedAt      *time.Time
+	ResponseTime     *schema.Interval
+	Breached         *bool
+}
+
+const callTimingsColumns = "emergency_call_timings.*"
+
+func intervalDuration(interval *schema.Interval) time.Duration {
+	if interval == nil {
+		return 0
+	}
+	return interval.Duration()
+}
+
+func (db *KwikMedicalDBClient) GetSLADefinitions() ([]SLADefinition, error) {
+	var rows []struct {
+		Severity       schema.InjurySeverity
+		ResponseTarget schema.Interval
+	}
+
+	err := db.gormDb.Table("sla_definitions").
+		Select("severity, response_target").
+		Order("response_target ASC").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	definitions := make([]SLADefinition, len(rows))
+	for i, row := range rows {
+		definitions[i] = SLADefinition{
+			Severity:       row.Severity,
+			ResponseTarget: row.ResponseTarget.Duration(),
+		}
+	}
+
+	return definitions, nil
+}
+
+// SetSLADefinition sets the response target for a severity, it applies to calls as their timings are next updated
+func (db *KwikMedicalDBClient) SetSLADefinition(definition SLADefinition) error {
+	if definition.ResponseTarget <= 0 {
+		return errors.New("an SLA response target must be positive")
+	}
+
+	return db.gormDb.Exec(`INSERT INTO sla_definitions (severity, response_target)
+		VALUES (?, ?)
+		ON CONFLICT (severity) DO UPDATE SET response_target = EXCLUDED.response_target, updated_at = CURRENT_TIMESTAMP`,
+		definition.Severity, schema.Interval(definition.ResponseTarget)).Error
+}
+
+// RecordAmbulanceArrival records the ambulance arriving on scene, which stops the response clock for the call
+func (db *KwikMedicalDBClient) RecordAmbulanceArrival(requestId uint) error {
+	result := db.gormDb.Table("ambulance_requests").
+		Where("request_id = ?", requestId).
+		Where("arrived_at IS NULL").
+		Update("arrived_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("no ambulance request %d awaiting arrival", requestId)
+	}
+
+	return nil
+}
+
+func (db *KwikMedicalDBClient) GetEmergencyCallTimings(callId uint) (*CallTimings, error) {
+	var row callTimingsRow
+
+	err := db.gormDb.Table("emergency_call_timings").
+		Select(callTimingsColumns).
+		Where("call_id = ?", callId).
+		Take(&row).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("no timings found for emergency call %d", callId)
+		}
+		return nil, err
+	}
+
+	timings := &CallTimings{
+		CallID:           row.CallID,
+		Severity:         row.Severity,
+		ResponseTarget:   intervalDuration(row.ResponseTarget),
+		CallTime:         row.CallTime,
+		RequestCreatedAt: row.RequestCreatedAt,
+		AcceptedAt:       row.AcceptedAt,
+		ArrivedAt:        row.ArrivedAt,
+		CompletedAt:      row.CompletedAt,
+		Breached:         row.Breached,
+	}
+	if row.ResponseTime != nil {
+		responseTime := row.ResponseTime.Duration()
+		timings.ResponseTime = &responseTime
+	}
+
+	return timings, nil
+}
+
+// ListSLABreaches lists the calls made between from and to that missed their response target, including
+// calls still waiting for an ambulance past their target. Calls completed without an arrival are not included.
+func (db *KwikMedicalDBClient) ListSLABreaches(from time.Time, to time.Time) ([]SLABreach, error) {
+	var rows []callTimingsRow
+
+	err := db.gormDb.Table("emergency_call_timings").
+		Select(callTimingsColumns).
+		Where("emergency_call_timings.call_time BETWEEN ? AND ?", from, to).
+		Where("emergency_call_timings.response_target IS NOT NULL").
+		Where(`emergency_call_timings.breached OR (
+			emergency_call_timings.arrived_at IS NULL AND emergency_call_timings.completed_at IS NULL
+			AND CURRENT_TIMESTAMP - emergency_call_timings.call_time > emergency_call_timings.response_target
+		)`).
+		Order("emergency_call_timings.call_time ASC").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	breaches := make([]SLABreach, len(rows))
+	for i, row := range rows {
+		breach := SLABreach{
+			CallID:         row.CallID,
+			Severity:       row.Severity,
+			CallTime:       row.CallTime,
+			ResponseTarget: intervalDuration(row.ResponseTarget),
+			Arrived:        row.ArrivedAt != nil,
+		}
+		if breach.Arrived {
+			breach.ResponseTime = intervalDuration(row.ResponseTime)
+		} else {
+			breach.ResponseTime = now.Sub(row.CallTime)
+		}
+		breaches[i] = breach
+	}
+
+	return breaches, nil
+}
+
+// GetCallsNearingSLABreach lists the calls still waiting for an ambulance that will breach their response
+// target within the given window, including those that already have, most urgent first.
+func (db *KwikMedicalDBClient) GetCallsNearingSLABreach(within time.Duration) ([]SLAWarning, error) {
+	var rows []callTimingsRow
+
+	err := db.gormDb.Table("emergency_call_timings").
+		Select(callTimingsColumns).
+		Joins("INNER JOIN emergency_calls ON emergency_calls.call_id = emergency_call_timings.call_id").
+		Where("emergency_calls.status <> ?", schema.Completed).
+		Where("emergency_call_timings.arrived_at IS NULL AND emergency_call_timings.completed_at IS NULL").
+		Where("emergency_call_timings.call_time + emergency_call_timings.response_target <= CURRENT_TIMESTAMP + ? * INTERVAL '1 second'",
+			within.Seconds()).
+		Order("emergency_call_timings.call_time + emergency_call_timings.response_target ASC").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	warnings := make([]SLAWarning, len(rows))
+	for i, row := range rows {
+		deadline := row.CallTime.Add(intervalDuration(row.ResponseTarget))
+		warnings[i] = SLAWarning{
+			CallID:    row.CallID,
+			Severity:  row.Severity,
+			CallTime:  row.CallTime,
+			Deadline:  deadline,
+			Remaining: deadline.Sub(now),
+		}
+	}
+
+	return warnings, nil
+}
+
+// WatchSLABreaches checks pending calls at the configured interval until the context is cancelled, calling
+// onWarning once for each call that comes within the warning window of breaching its response target.
+func (db *KwikMedicalDBClient) WatchSLABreaches(ctx context.Context, onWarning func(SLAWarning)) {
+	interval := db.config.SLAWatchInterval
+	if interval <= 0 {
+		interval = config.SLAWatchIntervalDefault
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	warned := make(map[uint]bool)
+	for {
+		warnings, err := db.GetCallsNearingSLABreach(db.config.SLAWarningWindow)
+		if err != nil {
+			db.logger.Error("Failed to check for SLA breaches", zap.Error(err))
+		} else {
+			// forget calls that are no longer pending so the map does not grow forever
+			pending := make(map[uint]bool, len(warnings))
+			for _, warning := range warnings {
+				pending[warning.CallID] = true
+				if !warned[warning.CallID] {
+					onWarning(warning)
+				}
+			}
+			warned = pending
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
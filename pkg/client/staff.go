@@ -0,0 +1,314 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/schema"
+	"github.com/jamieyoung5/kwikmedical-eventstream/pb"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+)
+
+// ErrIncompleteCrew is returned when an ambulance would be available, or left on a call, without at least one
+// active paramedic and driver
+var ErrIncompleteCrew = errors.New("ambulance needs at least one paramedic and one driver")
+
+// crewRoles are the roles that can be assigned to an ambulance
+var crewRoles = []schema.StaffRole{schema.Paramedic, schema.Driver, schema.Other}
+
+type StaffFilter struct {
+	Role            pb.StaffRole
+	AmbulanceID     uint
+	IncludeInactive bool
+}
+
+// CreateStaff adds a member of staff, who is always created active and without an ambulance, crews are assigned
+// with AssignStaffToAmbulance
+func (db *KwikMedicalDBClient) CreateStaff(staff *pb.AmbulanceStaff) (*pb.AmbulanceStaff, error) {
+	newStaff, err := schema.AmbulanceStaffPbToGorm(staff)
+	if err != nil {
+		return nil, err
+	}
+	if err = validateStaff(&newStaff); err != nil {
+		return nil, err
+	}
+
+	newStaff.StaffID = 0
+	newStaff.AmbulanceID = nil
+	newStaff.IsActive = true
+
+	if err = db.gormDb.Table("ambulance_staff").Create(&newStaff).Error; err != nil {
+		return nil, err
+	}
+
+	return newStaff.ToPb()
+}
+
+func (db *KwikMedicalDBClient) GetStaff(staffId uint) (*pb.AmbulanceStaff, error) {
+	staff, err := getStaff(db.gormDb, staffId)
+	if err != nil {
+		return nil, err
+	}
+
+	return staff.ToPb()
+}
+
+func (db *KwikMedicalDBClient) ListStaff(filter StaffFilter) ([]*pb.AmbulanceStaff, error) {
+	query := db.gormDb.Table("ambulance_staff")
+
+	if filter.Role != pb.StaffRole_UNKNOWN_STAFF_ROLE {
+		role, err := schema.StaffRoleFromPb(filter.Role)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("role = ?", role)
+	}
+	if filter.AmbulanceID != 0 {
+		query = query.Where("ambulance_id = ?", filter.AmbulanceID)
+	}
+	if !filter.IncludeInactive {
+		query = query.Where("is_active")
+	}
+
+	var staff []schema.AmbulanceStaff
+	if err := query.Order("last_name ASC, first_name ASC, staff_id ASC").Find(&staff).Error; err != nil {
+		return nil, err
+	}
+
+	results := make([]*pb.AmbulanceStaff, len(staff))
+	for i := range staff {
+		var err error
+		if results[i], err = staff[i].ToPb(); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// UpdateStaff updates a member of staff's details and role. Their ambulance and whether they are active are
+// changed through AssignStaffToAmbulance, UnassignStaff and DeactivateStaff.
+func (db *KwikMedicalDBClient) UpdateStaff(staff *pb.AmbulanceStaff) (*pb.AmbulanceStaff, error) {
+	updated, err := schema.AmbulanceStaffPbToGorm(staff)
+	if err != nil {
+		return nil, err
+	}
+	if err = validateStaff(&updated); err != nil {
+		return nil, err
+	}
+
+	var result *schema.AmbulanceStaff
+	err = db.DbTransaction(func(tx *gorm.DB) error {
+		current, err := lockStaff(tx, updated.StaffID)
+		if err != nil {
+			return err
+		}
+		if current.AmbulanceID != nil && current.IsActive && !isCrewRole(updated.Role) {
+			return fmt.Errorf("staff member %d is crewing ambulance %d and cannot become %s", current.StaffID, *current.AmbulanceID, updated.Role)
+		}
+
+		err = tx.Table("ambulance_staff").
+			Where("staff_id = ?", updated.StaffID).
+			Updates(map[string]interface{}{
+				"first_name":   updated.FirstName,
+				"last_name":    updated.LastName,
+				"phone_number": updated.PhoneNumber,
+				"email":        updated.Email,
+				"role":         updated.Role,
+			}).Error
+		if err != nil {
+			return err
+		}
+		if current.AmbulanceID != nil && current.IsActive && current.Role != updated.Role {
+			if err = requireCrewIfInService(tx, *current.AmbulanceID); err != nil {
+				return err
+			}
+		}
+
+		result, err = getStaff(tx, updated.StaffID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.ToPb()
+}
+
+// AssignStaffToAmbulance moves an active crew member onto an ambulance, taking them off any ambulance they
+// were crewing before
+func (db *KwikMedicalDBClient) AssignStaffToAmbulance(staffId uint, ambulanceId uint) error {
+	return db.DbTransaction(func(tx *gorm.DB) error {
+		staff, err := lockStaff(tx, staffId)
+		if err != nil {
+			return err
+		}
+		if !staff.IsActive {
+			return fmt.Errorf("staff member %d is not active", staffId)
+		}
+		if !isCrewRole(staff.Role) {
+			return fmt.Errorf("staff member %d is %s and cannot crew an ambulance", staffId, staff.Role)
+		}
+		if _, err = getAmbulance(tx.Clauses(clause.Locking{Strength: "UPDATE"}), ambulanceId); err != nil {
+			return err
+		}
+
+		err = tx.Table("ambulance_staff").
+			Where("staff_id = ?", staffId).
+			Update("ambulance_id", ambulanceId).Error
+		if err != nil {
+			return err
+		}
+
+		if staff.AmbulanceID != nil && *staff.AmbulanceID != ambulanceId {
+			return requireCrewIfInService(tx, *staff.AmbulanceID)
+		}
+		return nil
+	})
+}
+
+func (db *KwikMedicalDBClient) UnassignStaff(staffId uint) error {
+	return db.DbTransaction(func(tx *gorm.DB) error {
+		return removeFromCrew(tx, staffId, map[string]interface{}{"ambulance_id": nil})
+	})
+}
+
+// DeactivateStaff marks a member of staff as no longer working, taking them off their ambulance
+func (db *KwikMedicalDBClient) DeactivateStaff(staffId uint) error {
+	return db.DbTransaction(func(tx *gorm.DB) error {
+		return removeFromCrew(tx, staffId, map[string]interface{}{"ambulance_id": nil, "is_active": false})
+	})
+}
+
+func (db *KwikMedicalDBClient) ReactivateStaff(staffId uint) error {
+	result := db.gormDb.Table("ambulance_staff").
+		Where("staff_id = ?", staffId).
+		Update("is_active", true)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("no staff member found with id %d", staffId)
+	}
+	return nil
+}
+
+// GetCrewForAmbulance lists the active staff crewing an ambulance
+func (db *KwikMedicalDBClient) GetCrewForAmbulance(ambulanceId uint) ([]*pb.AmbulanceStaff, error) {
+	crew, err := getCrew(db.gormDb, ambulanceId)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*pb.AmbulanceStaff, len(crew))
+	for i := range crew {
+		var err error
+		if results[i], err = crew[i].ToPb(); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+func getCrew(tx *gorm.DB, ambulanceId uint) ([]schema.AmbulanceStaff, error) {
+	var crew []schema.AmbulanceStaff
+
+	err := tx.Table("ambulance_staff").
+		Where("ambulance_id = ? AND is_active", ambulanceId).
+		Order("role ASC, last_name ASC, first_name ASC").
+		Find(&crew).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return crew, nil
+}
+
+// requireCrew checks an ambulance has at least one active paramedic and driver
+func requireCrew(tx *gorm.DB, ambulanceId uint) error {
+	var roles []schema.StaffRole
+	err := tx.Table("ambulance_staff").
+		Where("ambulance_id = ? AND is_active", ambulanceId).
+		Where("role IN ?", []schema.StaffRole{schema.Paramedic, schema.Driver}).
+		Distinct().
+		Pluck("role", &roles).Error
+	if err != nil {
+		return err
+	}
+	if len(roles) < 2 {
+		return fmt.Errorf("%w: ambulance %d", ErrIncompleteCrew, ambulanceId)
+	}
+	return nil
+}
+
+// requireCrewIfInService checks an ambulance that is available or on a call still has a full crew after a crew
+// member has been taken off it. Ambulances in maintenance can be left without one.
+func requireCrewIfInService(tx *gorm.DB, ambulanceId uint) error {
+	ambulance, err := getAmbulance(tx.Clauses(clause.Locking{Strength: "UPDATE"}), ambulanceId)
+	if err != nil {
+		return err
+	}
+	if ambulance.Status != schema.Available && ambulance.Status != schema.OnCall {
+		return nil
+	}
+	return requireCrew(tx, ambulanceId)
+}
+
+func removeFromCrew(tx *gorm.DB, staffId uint, updates map[string]interface{}) error {
+	staff, err := lockStaff(tx, staffId)
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Table("ambulance_staff").Where("staff_id = ?", staffId).Updates(updates).Error; err != nil {
+		return err
+	}
+
+	if staff.AmbulanceID != nil && staff.IsActive {
+		return requireCrewIfInService(tx, *staff.AmbulanceID)
+	}
+	return nil
+}
+
+func validateStaff(staff *schema.AmbulanceStaff) error {
+	staff.FirstName = strings.TrimSpace(staff.FirstName)
+	staff.LastName = strings.TrimSpace(staff.LastName)
+	if staff.FirstName == "" || staff.LastName == "" {
+		return errors.New("staff members need a first and last name")
+	}
+	if staff.Role == schema.UnknownRole {
+		return errors.New("staff members need a role")
+	}
+	return nil
+}
+
+func isCrewRole(role schema.StaffRole) bool {
+	for _, crewRole := range crewRoles {
+		if role == crewRole {
+			return true
+		}
+	}
+	return false
+}
+
+func getStaff(tx *gorm.DB, staffId uint) (*schema.AmbulanceStaff, error) {
+	var staff schema.AmbulanceStaff
+
+	err := tx.Table("ambulance_staff").
+		Where("staff_id = ?", staffId).
+		Take(&staff).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("no staff member found with id %d", staffId)
+		}
+		return nil, err
+	}
+
+	return &staff, nil
+}
+
+func lockStaff(tx *gorm.DB, staffId uint) (*schema.AmbulanceStaff, error) {
+	return getStaff(tx.Clauses(clause.Locking{Strength: "UPDATE"}), staffId)
+}
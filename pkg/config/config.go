@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 const (
 	DbUserName        = EnvVarPrefix + "POSTGRESQL_USERNAME"
 	DbUserNameDefault = ""
@@ -11,6 +13,63 @@ const (
 	DbHostDefault = "localhost"
 
 	DbDatabaseName = "neondb"
+
+	PatientMatchThreshold        = EnvVarPrefix + "PATIENT_MATCH_THRESHOLD"
+	PatientMatchThresholdDefault = 0.85
+
+	PatientMatchLimit        = EnvVarPrefix + "PATIENT_MATCH_LIMIT"
+	PatientMatchLimitDefault = 5
+
+	PatientDuplicateThreshold        = EnvVarPrefix + "PATIENT_DUPLICATE_THRESHOLD"
+	PatientDuplicateThresholdDefault = 0.75
+
+	PatientMergeRetention        = EnvVarPrefix + "PATIENT_MERGE_RETENTION"
+	PatientMergeRetentionDefault = 30 * 24 * time.Hour
+
+	AlertRulesFile        = EnvVarPrefix + "ALERT_RULES_FILE"
+	AlertRulesFileDefault = ""
+
+	DispatchAgingInterval        = EnvVarPrefix + "DISPATCH_AGING_INTERVAL"
+	DispatchAgingIntervalDefault = 10 * time.Minute
+
+	DispatchClaimTimeout        = EnvVarPrefix + "DISPATCH_CLAIM_TIMEOUT"
+	DispatchClaimTimeoutDefault = 2 * time.Minute
+
+	CallDuplicateHandling        = EnvVarPrefix + "CALL_DUPLICATE_HANDLING"
+	CallDuplicateHandlingDefault = "ignore"
+
+	CallDuplicateWindow        = EnvVarPrefix + "CALL_DUPLICATE_WINDOW"
+	CallDuplicateWindowDefault = 30 * time.Minute
+
+	CallDuplicateRadius        = EnvVarPrefix + "CALL_DUPLICATE_RADIUS"
+	CallDuplicateRadiusDefault = 500.0
+
+	CallDuplicateSimilarity        = EnvVarPrefix + "CALL_DUPLICATE_SIMILARITY"
+	CallDuplicateSimilarityDefault = 0.3
+
+	SLAWatchInterval        = EnvVarPrefix + "SLA_WATCH_INTERVAL"
+	SLAWatchIntervalDefault = 30 * time.Second
+
+	SLAWarningWindow        = EnvVarPrefix + "SLA_WARNING_WINDOW"
+	SLAWarningWindowDefault = 2 * time.Minute
+
+	HeatmapCellSize        = EnvVarPrefix + "HEATMAP_CELL_SIZE"
+	HeatmapCellSizeDefault = 1000.0
+
+	HeatmapReferenceLatitude        = EnvVarPrefix + "HEATMAP_REFERENCE_LATITUDE"
+	HeatmapReferenceLatitudeDefault = 54.0
+
+	MaintenanceWatchInterval        = EnvVarPrefix + "MAINTENANCE_WATCH_INTERVAL"
+	MaintenanceWatchIntervalDefault = time.Minute
+
+	MaintenanceLookahead        = EnvVarPrefix + "MAINTENANCE_LOOKAHEAD"
+	MaintenanceLookaheadDefault = 2 * time.Hour
+
+	LongDistanceThreshold        = EnvVarPrefix + "LONG_DISTANCE_THRESHOLD"
+	LongDistanceThresholdDefault = 20000.0
+
+	ShiftMinimumRest        = EnvVarPrefix + "SHIFT_MINIMUM_REST"
+	ShiftMinimumRestDefault = 11 * time.Hour
 )
 
 type Config struct {
@@ -19,6 +78,54 @@ type Config struct {
 	Engine       string
 	Host         string
 	DatabaseName string
+
+	// PatientMatchThreshold is the minimum confidence (0-1) at which a fuzzy patient match is
+	// linked automatically rather than handed back to the call handler as a candidate.
+	PatientMatchThreshold float64
+	PatientMatchLimit     int
+
+	// PatientDuplicateThreshold is the minimum confidence at which two existing patients are proposed as duplicates
+	PatientDuplicateThreshold float64
+	// PatientMergeRetention is how long a merge can still be reverted
+	PatientMergeRetention time.Duration
+
+	// AlertRulesFile is a JSON file of patient alert rules, the built-in rules are used when it is empty
+	AlertRulesFile string
+
+	// DispatchAgingInterval is how long a call waits in the dispatch queue before it is ranked one severity higher
+	DispatchAgingInterval time.Duration
+	// DispatchClaimTimeout is how long a dispatcher can hold a dequeued request before it is handed to another
+	DispatchClaimTimeout time.Duration
+
+	// CallDuplicateHandling is what happens to a new emergency call that looks like a duplicate: "ignore" inserts it
+	// as normal, "link" inserts it linked to the earlier call and "return" hands the earlier calls back instead
+	CallDuplicateHandling string
+	// CallDuplicateWindow is how far apart two calls can be made and still be about the same event
+	CallDuplicateWindow time.Duration
+	// CallDuplicateRadius is how far apart in metres two calls can be and still be about the same event
+	CallDuplicateRadius float64
+	// CallDuplicateSimilarity is the minimum trigram similarity (0-1) between the medical conditions of nearby calls
+	CallDuplicateSimilarity float64
+
+	// SLAWatchInterval is how often the SLA watcher checks on pending calls
+	SLAWatchInterval time.Duration
+	// SLAWarningWindow is how long before a call breaches its response target that the watcher flags it
+	SLAWarningWindow time.Duration
+
+	// HeatmapCellSize is the default size in metres of the cells calls are aggregated into for density heatmaps
+	HeatmapCellSize float64
+	// HeatmapReferenceLatitude is the latitude heatmaps are projected around, fixed so cells line up between maps
+	HeatmapReferenceLatitude float64
+
+	// MaintenanceWatchInterval is how often the maintenance watcher starts windows that have fallen due
+	MaintenanceWatchInterval time.Duration
+	// MaintenanceLookahead is how soon maintenance has to be due for an ambulance to be kept off long-distance calls
+	MaintenanceLookahead time.Duration
+	// LongDistanceThreshold is the distance in metres from an ambulance to a call beyond which the call is long-distance
+	LongDistanceThreshold float64
+
+	// ShiftMinimumRest is the shortest break staff must have between the end of one shift and the start of the next
+	ShiftMinimumRest time.Duration
 }
 
 func NewConfig() *Config {
@@ -27,12 +134,68 @@ func NewConfig() *Config {
 		DbUserName: DbUserNameDefault,
 		DbPassword: DbPasswordDefault,
 		DbHost:     DbHostDefault,
+
+		PatientMatchThreshold: PatientMatchThresholdDefault,
+		PatientMatchLimit:     PatientMatchLimitDefault,
+
+		PatientDuplicateThreshold: PatientDuplicateThresholdDefault,
+		PatientMergeRetention:     PatientMergeRetentionDefault,
+
+		AlertRulesFile: AlertRulesFileDefault,
+
+		DispatchAgingInterval: DispatchAgingIntervalDefault,
+		DispatchClaimTimeout:  DispatchClaimTimeoutDefault,
+
+		CallDuplicateHandling:   CallDuplicateHandlingDefault,
+		CallDuplicateWindow:     CallDuplicateWindowDefault,
+		CallDuplicateRadius:     CallDuplicateRadiusDefault,
+		CallDuplicateSimilarity: CallDuplicateSimilarityDefault,
+
+		SLAWatchInterval: SLAWatchIntervalDefault,
+		SLAWarningWindow: SLAWarningWindowDefault,
+
+		HeatmapCellSize:          HeatmapCellSizeDefault,
+		HeatmapReferenceLatitude: HeatmapReferenceLatitudeDefault,
+
+		MaintenanceWatchInterval: MaintenanceWatchIntervalDefault,
+		MaintenanceLookahead:     MaintenanceLookaheadDefault,
+		LongDistanceThreshold:    LongDistanceThresholdDefault,
+
+		ShiftMinimumRest: ShiftMinimumRestDefault,
 	})
 	config := Config{
 		UserName:     av.GetString(DbUserName),
 		Password:     av.GetString(DbPassword),
 		Host:         av.GetString(DbHost),
 		DatabaseName: DbDatabaseName,
+
+		PatientMatchThreshold: av.GetFloat64(PatientMatchThreshold),
+		PatientMatchLimit:     av.GetInt(PatientMatchLimit),
+
+		PatientDuplicateThreshold: av.GetFloat64(PatientDuplicateThreshold),
+		PatientMergeRetention:     av.GetDuration(PatientMergeRetention),
+
+		AlertRulesFile: av.GetString(AlertRulesFile),
+
+		DispatchAgingInterval: av.GetDuration(DispatchAgingInterval),
+		DispatchClaimTimeout:  av.GetDuration(DispatchClaimTimeout),
+
+		CallDuplicateHandling:   av.GetString(CallDuplicateHandling),
+		CallDuplicateWindow:     av.GetDuration(CallDuplicateWindow),
+		CallDuplicateRadius:     av.GetFloat64(CallDuplicateRadius),
+		CallDuplicateSimilarity: av.GetFloat64(CallDuplicateSimilarity),
+
+		SLAWatchInterval: av.GetDuration(SLAWatchInterval),
+		SLAWarningWindow: av.GetDuration(SLAWarningWindow),
+
+		HeatmapCellSize:          av.GetFloat64(HeatmapCellSize),
+		HeatmapReferenceLatitude: av.GetFloat64(HeatmapReferenceLatitude),
+
+		MaintenanceWatchInterval: av.GetDuration(MaintenanceWatchInterval),
+		MaintenanceLookahead:     av.GetDuration(MaintenanceLookahead),
+		LongDistanceThreshold:    av.GetFloat64(LongDistanceThreshold),
+
+		ShiftMinimumRest: av.GetDuration(ShiftMinimumRest),
 	}
 
 	return &config
@@ -0,0 +1,307 @@
+package fhir
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/schema"
+	"html"
+	"strconv"
+	"time"
+)
+
+const dateFormat = "2006-01-02"
+
+// PatientExport is everything known about a patient that is shared with a receiving hospital.
+type PatientExport struct {
+	Patient        *schema.Patient
+	Entries        *schema.ClinicalEntries
+	EmergencyCalls []schema.EmergencyCall
+	Callouts       []schema.CallOutDetails
+}
+
+// NewPatientBundle converts a patient and their history into a FHIR R4 collection Bundle.
+func NewPatientBundle(export PatientExport) (*Bundle, error) {
+	bundle := &Bundle{
+		ResourceType: "Bundle",
+		Type:         BundleTypeCollection,
+		Timestamp:    time.Now().UTC().Format(time.RFC3339),
+	}
+
+	add := func(resource any) error {
+		raw, err := json.Marshal(resource)
+		if err != nil {
+			return fmt.Errorf("failed to marshal FHIR resource: %w", err)
+		}
+		bundle.Entry = append(bundle.Entry, BundleEntry{Resource: raw})
+		return nil
+	}
+
+	patient := PatientToFhir(export.Patient)
+	if err := add(patient); err != nil {
+		return nil, err
+	}
+	subject := Reference{Reference: "Patient/" + patient.ID}
+
+	if export.Entries != nil {
+		for i := range export.Entries.Conditions {
+			if err := add(ConditionToFhir(&export.Entries.Conditions[i], subject)); err != nil {
+				return nil, err
+			}
+		}
+		for i := range export.Entries.Allergies {
+			if err := add(AllergyToFhir(&export.Entries.Allergies[i], subject)); err != nil {
+				return nil, err
+			}
+		}
+		for i := range export.Entries.Medications {
+			if err := add(MedicationToFhir(&export.Entries.Medications[i], subject)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for i := range export.EmergencyCalls {
+		encounter, location := EmergencyCallToFhir(&export.EmergencyCalls[i])
+		if err := add(location); err != nil {
+			return nil, err
+		}
+		if err := add(encounter); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range export.Callouts {
+		if err := add(CalloutToFhir(&export.Callouts[i], &subject)); err != nil {
+			return nil, err
+		}
+	}
+
+	return bundle, nil
+}
+
+func PatientToFhir(p *schema.Patient) Patient {
+	patient := Patient{
+		ResourceType: "Patient",
+		ID:           strconv.Itoa(int(p.PatientID)),
+		Name: []HumanName{{
+			Use:    "official",
+			Family: p.LastName,
+			Given:  []string{p.FirstName},
+		}},
+		BirthDate: formatDate(p.DateOfBirth),
+	}
+
+	if p.NHSNumber != "" {
+		patient.Identifier = []Identifier{{System: NHSNumberSystem, Value: p.NHSNumber}}
+	}
+	if p.Address != "" {
+		patient.Address = []Address{{Text: p.Address}}
+	}
+	if p.PhoneNumber != "" {
+		patient.Telecom = append(patient.Telecom, ContactPoint{System: "phone", Value: p.PhoneNumber})
+	}
+	if p.Email != "" {
+		patient.Telecom = append(patient.Telecom, ContactPoint{System: "email", Value: p.Email})
+	}
+	if !p.CreatedAt.IsZero() {
+		patient.Meta = &Meta{LastUpdated: p.CreatedAt.UTC().Format(time.RFC3339)}
+	}
+
+	return patient
+}
+
+func ConditionToFhir(c *schema.MedicalCondition, subject Reference) Condition {
+	return Condition{
+		ResourceType:   "Condition",
+		ID:             strconv.Itoa(int(c.ConditionID)),
+		ClinicalStatus: &CodeableConcept{Coding: []Coding{{System: ConditionClinicalSystem, Code: "active"}}},
+		Code:           codeableConcept(SnomedSystem, c.SnomedCode, c.Description),
+		Subject:        subject,
+		OnsetDateTime:  formatOptionalDate(c.OnsetDate),
+		RecordedDate:   formatTime(c.RecordedAt),
+	}
+}
+
+func AllergyToFhir(a *schema.MedicalAllergy, patient Reference) AllergyIntolerance {
+	allergy := AllergyIntolerance{
+		ResourceType:   "AllergyIntolerance",
+		ID:             strconv.Itoa(int(a.AllergyID)),
+		ClinicalStatus: &CodeableConcept{Coding: []Coding{{System: AllergyClinicalSystem, Code: "active"}}},
+		Criticality:    allergyCriticality(a.Severity),
+		Code:           codeableConcept(SnomedSystem, a.SnomedCode, a.Substance),
+		Patient:        patient,
+		OnsetDateTime:  formatOptionalDate(a.OnsetDate),
+		RecordedDate:   formatTime(a.RecordedAt),
+	}
+
+	if a.Reaction != "" || allergyReactionSeverity(a.Severity) != "" {
+		allergy.Reaction = []AllergyReaction{{
+			Manifestation: []CodeableConcept{{Text: a.Reaction}},
+			Severity:      allergyReactionSeverity(a.Severity),
+		}}
+	}
+
+	return allergy
+}
+
+func MedicationToFhir(m *schema.MedicalMedication, subject Reference) MedicationStatement {
+	medication := MedicationStatement{
+		ResourceType:              "MedicationStatement",
+		ID:                        strconv.Itoa(int(m.MedicationID)),
+		Status:                    "active",
+		MedicationCodeableConcept: codeableConcept(DmdSystem, m.DmdCode, m.Name),
+		Subject:                   subject,
+		EffectiveDateTime:         formatOptionalDate(m.StartDate),
+		DateAsserted:              formatTime(m.RecordedAt),
+	}
+
+	if m.Dosage != "" {
+		medication.Dosage = []Dosage{{Text: m.Dosage}}
+	}
+
+	return medication
+}
+
+// EmergencyCallToFhir converts a call into an emergency Encounter along with the Location it was made from.
+func EmergencyCallToFhir(call *schema.EmergencyCall) (Encounter, Location) {
+	id := "call-" + strconv.Itoa(int(call.CallID))
+
+	location := Location{
+		ResourceType: "Location",
+		ID:           id,
+		Name:         "Emergency call location",
+		Position: &Position{
+			Latitude:  call.Location.Latitude,
+			Longitude: call.Location.Longitude,
+		},
+	}
+
+	encounter := Encounter{
+		ResourceType: "Encounter",
+		ID:           id,
+		Status:       encounterStatus(call.Status),
+		Class:        Coding{System: ActCodeSystem, Code: "EMER", Display: "emergency"},
+		Priority:     &CodeableConcept{Coding: []Coding{encounterPriority(call.Severity)}, Text: string(call.Severity)},
+		Period:       &Period{Start: formatTime(call.CallTime)},
+		Location:     []EncounterLocation{{Location: Reference{Reference: "Location/" + id}}},
+	}
+	if call.PatientID != nil {
+		encounter.Subject = &Reference{Reference: "Patient/" + strconv.Itoa(int(*call.PatientID))}
+	}
+	if call.MedicalCondition != "" {
+		encounter.ReasonCode = []CodeableConcept{{Text: call.MedicalCondition}}
+	}
+
+	return encounter, location
+}
+
+// CalloutToFhir converts a callout into an Encounter that is part of its emergency call's Encounter.
+func CalloutToFhir(callout *schema.CallOutDetails, subject *Reference) Encounter {
+	encounter := Encounter{
+		ResourceType: "Encounter",
+		ID:           "callout-" + strconv.Itoa(int(callout.DetailID)),
+		Status:       "finished",
+		Class:        Coding{System: ActCodeSystem, Code: "FLD", Display: "field"},
+		Subject:      subject,
+		Period:       &Period{Start: formatTime(callout.CreatedAt)},
+		PartOf:       &Reference{Reference: "Encounter/call-" + strconv.Itoa(int(callout.CallID))},
+	}
+
+	if callout.ActionTaken != "" {
+		encounter.Type = []CodeableConcept{{Text: callout.ActionTaken}}
+	}
+	if callout.Notes != "" {
+		encounter.Text = &Narrative{
+			Status: "generated",
+			Div:    `<div xmlns="http://www.w3.org/1999/xhtml">` + html.EscapeString(callout.Notes) + `</div>`,
+		}
+	}
+	if timeSpent := callout.ToPb().TimeSpent; timeSpent != nil {
+		encounter.Length = &Duration{
+			Value:  timeSpent.AsDuration().Minutes(),
+			Unit:   "min",
+			System: "http://unitsofmeasure.org",
+			Code:   "min",
+		}
+	}
+
+	return encounter
+}
+
+func codeableConcept(system string, code string, text string) CodeableConcept {
+	concept := CodeableConcept{Text: text}
+	if code != "" {
+		concept.Coding = []Coding{{System: system, Code: code, Display: text}}
+	}
+	return concept
+}
+
+func encounterStatus(status schema.EmergencyCallStatus) string {
+	switch status {
+	case schema.Pending:
+		return "planned"
+	case schema.Dispatched:
+		return "in-progress"
+	case schema.Completed:
+		return "finished"
+	default:
+		return "unknown"
+	}
+}
+
+func encounterPriority(severity schema.InjurySeverity) Coding {
+	switch severity {
+	case schema.Critical, schema.High:
+		return Coding{System: PrioritySystem, Code: "EM", Display: "emergency"}
+	case schema.Moderate:
+		return Coding{System: PrioritySystem, Code: "UR", Display: "urgent"}
+	default:
+		return Coding{System: PrioritySystem, Code: "R", Display: "routine"}
+	}
+}
+
+func allergyCriticality(severity schema.AllergySeverity) string {
+	switch severity {
+	case schema.AllergySevere, schema.AllergyLifeThreatening:
+		return "high"
+	case schema.AllergyMild, schema.AllergyModerate:
+		return "low"
+	default:
+		return "unable-to-assess"
+	}
+}
+
+func allergyReactionSeverity(severity schema.AllergySeverity) string {
+	switch severity {
+	case schema.AllergyMild:
+		return "mild"
+	case schema.AllergyModerate:
+		return "moderate"
+	case schema.AllergySevere, schema.AllergyLifeThreatening:
+		return "severe"
+	default:
+		return ""
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func formatOptionalDate(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.Format(dateFormat)
+}
+
+// formatDate trims a postgres date, which may come back as a full timestamp, down to a FHIR date
+func formatDate(date string) string {
+	if len(date) > len(dateFormat) {
+		return date[:len(dateFormat)]
+	}
+	return date
+}
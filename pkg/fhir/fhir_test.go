@@ -0,0 +1,98 @@
+package fhir
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/schema"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPatientBundleRoundTrip(t *testing.T) {
+	onset := time.Date(2015, time.March, 4, 0, 0, 0, 0, time.UTC)
+	patient := schema.Patient{
+		PatientID:   7,
+		NHSNumber:   "9434765919",
+		FirstName:   "Jane",
+		LastName:    "Doe",
+		DateOfBirth: "1980-02-01T00:00:00Z",
+		Address:     "1 High Street, Leeds",
+		PhoneNumber: "07700 900123",
+		Email:       "jane@example.com",
+		CreatedAt:   time.Date(2024, time.May, 6, 7, 8, 9, 0, time.UTC),
+	}
+	entries := schema.ClinicalEntries{
+		Conditions: []schema.MedicalCondition{
+			{ConditionID: 1, SnomedCode: "73211009", Description: "Diabetes mellitus", OnsetDate: &onset},
+			{ConditionID: 2, Description: "Asthma"},
+		},
+		Allergies: []schema.MedicalAllergy{
+			{AllergyID: 3, SnomedCode: "91936005", Substance: "Penicillin", Reaction: "Rash", Severity: schema.AllergySevere},
+			{AllergyID: 4, Substance: "Peanut", Severity: schema.AllergyMild},
+		},
+		Medications: []schema.MedicalMedication{
+			{MedicationID: 5, DmdCode: "319740004", Name: "Warfarin 5mg tablets", Dosage: "5mg daily", StartDate: &onset},
+		},
+	}
+
+	bundle, err := NewPatientBundle(PatientExport{Patient: &patient, Entries: &entries})
+	if err != nil {
+		t.Fatalf("NewPatientBundle() returned error %v", err)
+	}
+	raw, err := json.Marshal(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseBundle(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ParseBundle() returned error %v", err)
+	}
+	got, err := parsed.Patients()
+	if err != nil {
+		t.Fatalf("Patients() returned error %v", err)
+	}
+
+	// ids and timestamps are assigned by the importing database, so they are not carried over
+	want := []PatientImport{{
+		Patient: schema.Patient{
+			NHSNumber:   patient.NHSNumber,
+			FirstName:   patient.FirstName,
+			LastName:    patient.LastName,
+			DateOfBirth: "1980-02-01",
+			Address:     patient.Address,
+			PhoneNumber: patient.PhoneNumber,
+			Email:       patient.Email,
+		},
+		Entries: schema.ClinicalEntries{
+			Conditions: []schema.MedicalCondition{
+				{SnomedCode: "73211009", Description: "Diabetes mellitus", OnsetDate: &onset},
+				{Description: "Asthma"},
+			},
+			Allergies: []schema.MedicalAllergy{
+				{SnomedCode: "91936005", Substance: "Penicillin", Reaction: "Rash", Severity: schema.AllergySevere},
+				{Substance: "Peanut", Severity: schema.AllergyMild},
+			},
+			Medications: []schema.MedicalMedication{
+				{DmdCode: "319740004", Name: "Warfarin 5mg tablets", Dosage: "5mg daily", StartDate: &onset},
+			},
+		},
+	}}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Patients() = %+v, want %+v", got, want)
+	}
+}
+
+func TestPatientsMissingNHSNumber(t *testing.T) {
+	bundle, err := NewPatientBundle(PatientExport{Patient: &schema.Patient{PatientID: 7, FirstName: "Jane", LastName: "Doe"}})
+	if err != nil {
+		t.Fatalf("NewPatientBundle() returned error %v", err)
+	}
+
+	if _, err = bundle.Patients(); !errors.Is(err, ErrMissingNHSNumber) {
+		t.Errorf("Patients() returned error %v, want %v", err, ErrMissingNHSNumber)
+	}
+}
@@ -0,0 +1,280 @@
+package fhir
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/schema"
+	"io"
+	"strings"
+	"time"
+)
+
+var ErrMissingNHSNumber = errors.New("FHIR patient has no NHS number identifier")
+
+// PatientImport is a patient and their structured clinical entries read from a FHIR Bundle.
+type PatientImport struct {
+	Patient schema.Patient
+	Entries schema.ClinicalEntries
+}
+
+type resourceHeader struct {
+	ResourceType string `json:"resourceType"`
+	ID           string `json:"id"`
+}
+
+func ParseBundle(r io.Reader) (*Bundle, error) {
+	var bundle Bundle
+	if err := json.NewDecoder(r).Decode(&bundle); err != nil {
+		return nil, fmt.Errorf("failed to parse FHIR bundle: %w", err)
+	}
+	if bundle.ResourceType != "Bundle" {
+		return nil, fmt.Errorf("expected a FHIR Bundle, got %q", bundle.ResourceType)
+	}
+
+	return &bundle, nil
+}
+
+// Patients reads the Patient resources of the bundle along with the Conditions, AllergyIntolerances and
+// MedicationStatements that reference them. Resources of other types, or referencing patients not in the
+// bundle, are ignored.
+func (b *Bundle) Patients() ([]PatientImport, error) {
+	var (
+		imports    []*PatientImport
+		references = map[string]*PatientImport{}
+		clinical   []json.RawMessage
+	)
+
+	for _, entry := range b.Entry {
+		var header resourceHeader
+		if err := json.Unmarshal(entry.Resource, &header); err != nil {
+			return nil, fmt.Errorf("failed to parse FHIR resource: %w", err)
+		}
+
+		switch header.ResourceType {
+		case "Patient":
+			var resource Patient
+			if err := json.Unmarshal(entry.Resource, &resource); err != nil {
+				return nil, fmt.Errorf("failed to parse FHIR patient: %w", err)
+			}
+
+			patient, err := PatientFromFhir(&resource)
+			if err != nil {
+				return nil, err
+			}
+
+			patientImport := &PatientImport{Patient: patient}
+			imports = append(imports, patientImport)
+			references["Patient/"+header.ID] = patientImport
+			if entry.FullURL != "" {
+				references[entry.FullURL] = patientImport
+			}
+		case "Condition", "AllergyIntolerance", "MedicationStatement":
+			clinical = append(clinical, entry.Resource)
+		}
+	}
+
+	// clinical resources are read once all patients are known, since bundles are not necessarily ordered
+	for _, raw := range clinical {
+		var header resourceHeader
+		_ = json.Unmarshal(raw, &header)
+
+		switch header.ResourceType {
+		case "Condition":
+			var resource Condition
+			if err := json.Unmarshal(raw, &resource); err != nil {
+				return nil, fmt.Errorf("failed to parse FHIR condition: %w", err)
+			}
+			if patientImport, ok := references[resource.Subject.Reference]; ok {
+				patientImport.Entries.Conditions = append(patientImport.Entries.Conditions, ConditionFromFhir(&resource))
+			}
+		case "AllergyIntolerance":
+			var resource AllergyIntolerance
+			if err := json.Unmarshal(raw, &resource); err != nil {
+				return nil, fmt.Errorf("failed to parse FHIR allergy intolerance: %w", err)
+			}
+			if patientImport, ok := references[resource.Patient.Reference]; ok {
+				patientImport.Entries.Allergies = append(patientImport.Entries.Allergies, AllergyFromFhir(&resource))
+			}
+		case "MedicationStatement":
+			var resource MedicationStatement
+			if err := json.Unmarshal(raw, &resource); err != nil {
+				return nil, fmt.Errorf("failed to parse FHIR medication statement: %w", err)
+			}
+			if patientImport, ok := references[resource.Subject.Reference]; ok {
+				patientImport.Entries.Medications = append(patientImport.Entries.Medications, MedicationFromFhir(&resource))
+			}
+		}
+	}
+
+	result := make([]PatientImport, len(imports))
+	for i, patientImport := range imports {
+		result[i] = *patientImport
+	}
+
+	return result, nil
+}
+
+func PatientFromFhir(p *Patient) (schema.Patient, error) {
+	patient := schema.Patient{DateOfBirth: p.BirthDate}
+
+	for _, identifier := range p.Identifier {
+		if identifier.System == NHSNumberSystem {
+			patient.NHSNumber = identifier.Value
+		}
+	}
+	if patient.NHSNumber == "" {
+		return patient, fmt.Errorf("%w: patient %q", ErrMissingNHSNumber, p.ID)
+	}
+
+	if len(p.Name) > 0 {
+		name := p.Name[0]
+		for _, candidate := range p.Name {
+			if candidate.Use == "official" {
+				name = candidate
+				break
+			}
+		}
+		patient.LastName = name.Family
+		patient.FirstName = strings.Join(name.Given, " ")
+	}
+
+	if len(p.Address) > 0 {
+		address := p.Address[0]
+		patient.Address = address.Text
+		if patient.Address == "" {
+			parts := append(append([]string{}, address.Line...), address.City, address.PostalCode)
+			patient.Address = joinNonEmpty(parts, ", ")
+		}
+	}
+
+	for _, telecom := range p.Telecom {
+		switch telecom.System {
+		case "phone":
+			if patient.PhoneNumber == "" {
+				patient.PhoneNumber = telecom.Value
+			}
+		case "email":
+			if patient.Email == "" {
+				patient.Email = telecom.Value
+			}
+		}
+	}
+
+	return patient, nil
+}
+
+func ConditionFromFhir(c *Condition) schema.MedicalCondition {
+	code, text := conceptCode(c.Code, SnomedSystem)
+
+	return schema.MedicalCondition{
+		SnomedCode:  code,
+		Description: text,
+		OnsetDate:   parseDate(c.OnsetDateTime),
+	}
+}
+
+func AllergyFromFhir(a *AllergyIntolerance) schema.MedicalAllergy {
+	code, text := conceptCode(a.Code, SnomedSystem)
+
+	allergy := schema.MedicalAllergy{
+		SnomedCode: code,
+		Substance:  text,
+		Severity:   schema.UnknownAllergySeverity,
+		OnsetDate:  parseDate(a.OnsetDateTime),
+	}
+
+	if a.Criticality == "high" {
+		allergy.Severity = schema.AllergySevere
+	}
+
+	var reactions []string
+	for _, reaction := range a.Reaction {
+		for _, manifestation := range reaction.Manifestation {
+			if _, manifestationText := conceptCode(manifestation, SnomedSystem); manifestationText != "" {
+				reactions = append(reactions, manifestationText)
+			}
+		}
+
+		switch reaction.Severity {
+		case "severe":
+			allergy.Severity = schema.AllergySevere
+		case "moderate":
+			if allergy.Severity != schema.AllergySevere {
+				allergy.Severity = schema.AllergyModerate
+			}
+		case "mild":
+			if allergy.Severity == schema.UnknownAllergySeverity {
+				allergy.Severity = schema.AllergyMild
+			}
+		}
+	}
+	allergy.Reaction = strings.Join(reactions, ", ")
+
+	return allergy
+}
+
+func MedicationFromFhir(m *MedicationStatement) schema.MedicalMedication {
+	code, text := conceptCode(m.MedicationCodeableConcept, DmdSystem)
+
+	medication := schema.MedicalMedication{
+		DmdCode:   code,
+		Name:      text,
+		StartDate: parseDate(m.EffectiveDateTime),
+	}
+
+	var dosages []string
+	for _, dosage := range m.Dosage {
+		if dosage.Text != "" {
+			dosages = append(dosages, dosage.Text)
+		}
+	}
+	medication.Dosage = strings.Join(dosages, "; ")
+
+	return medication
+}
+
+// conceptCode returns the code from the given system, if any, and the best available text for the concept
+func conceptCode(concept CodeableConcept, system string) (string, string) {
+	var code, display string
+	for _, coding := range concept.Coding {
+		if coding.System == system && code == "" {
+			code = coding.Code
+			display = coding.Display
+		}
+		if display == "" {
+			display = coding.Display
+		}
+	}
+
+	if concept.Text != "" {
+		return code, concept.Text
+	}
+	if display != "" {
+		return code, display
+	}
+	return code, code
+}
+
+// parseDate reads the date part of a FHIR date or dateTime
+func parseDate(value string) *time.Time {
+	if len(value) < len(dateFormat) {
+		return nil
+	}
+
+	date, err := time.Parse(dateFormat, value[:len(dateFormat)])
+	if err != nil {
+		return nil
+	}
+	return &date
+}
+
+func joinNonEmpty(values []string, separator string) string {
+	var parts []string
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			parts = append(parts, strings.TrimSpace(value))
+		}
+	}
+	return strings.Join(parts, separator)
+}
@@ -0,0 +1,177 @@
+package fhir
+
+import "encoding/json"
+
+// The types below cover the subset of HL7 FHIR R4 needed to exchange patient data with receiving hospitals.
+
+const (
+	NHSNumberSystem = "https://fhir.nhs.uk/Id/nhs-number"
+	SnomedSystem    = "http://snomed.info/sct"
+	DmdSystem       = "https://dmd.nhs.uk"
+	ActCodeSystem   = "http://terminology.hl7.org/CodeSystem/v3-ActCode"
+	PrioritySystem  = "http://terminology.hl7.org/CodeSystem/v3-ActPriority"
+
+	ConditionClinicalSystem = "http://terminology.hl7.org/CodeSystem/condition-clinical"
+	AllergyClinicalSystem   = "http://terminology.hl7.org/CodeSystem/allergyintolerance-clinical"
+
+	BundleTypeCollection  = "collection"
+	BundleTypeTransaction = "transaction"
+)
+
+type Bundle struct {
+	ResourceType string        `json:"resourceType"`
+	Type         string        `json:"type"`
+	Timestamp    string        `json:"timestamp,omitempty"`
+	Entry        []BundleEntry `json:"entry,omitempty"`
+}
+
+type BundleEntry struct {
+	FullURL  string          `json:"fullUrl,omitempty"`
+	Resource json.RawMessage `json:"resource"`
+}
+
+type Identifier struct {
+	System string `json:"system,omitempty"`
+	Value  string `json:"value,omitempty"`
+}
+
+type Coding struct {
+	System  string `json:"system,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+type CodeableConcept struct {
+	Coding []Coding `json:"coding,omitempty"`
+	Text   string   `json:"text,omitempty"`
+}
+
+type Reference struct {
+	Reference string `json:"reference,omitempty"`
+	Display   string `json:"display,omitempty"`
+}
+
+type HumanName struct {
+	Use    string   `json:"use,omitempty"`
+	Family string   `json:"family,omitempty"`
+	Given  []string `json:"given,omitempty"`
+}
+
+type ContactPoint struct {
+	System string `json:"system,omitempty"`
+	Value  string `json:"value,omitempty"`
+}
+
+type Address struct {
+	Text string   `json:"text,omitempty"`
+	Line []string `json:"line,omitempty"`
+	City string   `json:"city,omitempty"`
+	// PostalCode is only read on import, exported addresses are free text
+	PostalCode string `json:"postalCode,omitempty"`
+}
+
+type Period struct {
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+}
+
+type Duration struct {
+	Value  float64 `json:"value"`
+	Unit   string  `json:"unit,omitempty"`
+	System string  `json:"system,omitempty"`
+	Code   string  `json:"code,omitempty"`
+}
+
+type Narrative struct {
+	Status string `json:"status"`
+	Div    string `json:"div"`
+}
+
+type Meta struct {
+	LastUpdated string `json:"lastUpdated,omitempty"`
+}
+
+type Patient struct {
+	ResourceType string         `json:"resourceType"`
+	ID           string         `json:"id,omitempty"`
+	Meta         *Meta          `json:"meta,omitempty"`
+	Identifier   []Identifier   `json:"identifier,omitempty"`
+	Name         []HumanName    `json:"name,omitempty"`
+	Telecom      []ContactPoint `json:"telecom,omitempty"`
+	BirthDate    string         `json:"birthDate,omitempty"`
+	Address      []Address      `json:"address,omitempty"`
+}
+
+type Condition struct {
+	ResourceType   string           `json:"resourceType"`
+	ID             string           `json:"id,omitempty"`
+	ClinicalStatus *CodeableConcept `json:"clinicalStatus,omitempty"`
+	Code           CodeableConcept  `json:"code"`
+	Subject        Reference        `json:"subject"`
+	OnsetDateTime  string           `json:"onsetDateTime,omitempty"`
+	RecordedDate   string           `json:"recordedDate,omitempty"`
+}
+
+type AllergyReaction struct {
+	Manifestation []CodeableConcept `json:"manifestation"`
+	Severity      string            `json:"severity,omitempty"`
+}
+
+type AllergyIntolerance struct {
+	ResourceType   string            `json:"resourceType"`
+	ID             string            `json:"id,omitempty"`
+	ClinicalStatus *CodeableConcept  `json:"clinicalStatus,omitempty"`
+	Criticality    string            `json:"criticality,omitempty"`
+	Code           CodeableConcept   `json:"code"`
+	Patient        Reference         `json:"patient"`
+	OnsetDateTime  string            `json:"onsetDateTime,omitempty"`
+	RecordedDate   string            `json:"recordedDate,omitempty"`
+	Reaction       []AllergyReaction `json:"reaction,omitempty"`
+}
+
+type Dosage struct {
+	Text string `json:"text,omitempty"`
+}
+
+type MedicationStatement struct {
+	ResourceType              string          `json:"resourceType"`
+	ID                        string          `json:"id,omitempty"`
+	Status                    string          `json:"status"`
+	MedicationCodeableConcept CodeableConcept `json:"medicationCodeableConcept"`
+	Subject                   Reference       `json:"subject"`
+	EffectiveDateTime         string          `json:"effectiveDateTime,omitempty"`
+	DateAsserted              string          `json:"dateAsserted,omitempty"`
+	Dosage                    []Dosage        `json:"dosage,omitempty"`
+}
+
+type Position struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+type Location struct {
+	ResourceType string    `json:"resourceType"`
+	ID           string    `json:"id,omitempty"`
+	Name         string    `json:"name,omitempty"`
+	Position     *Position `json:"position,omitempty"`
+}
+
+type EncounterLocation struct {
+	Location Reference `json:"location"`
+}
+
+type Encounter struct {
+	ResourceType string              `json:"resourceType"`
+	ID           string              `json:"id,omitempty"`
+	Text         *Narrative          `json:"text,omitempty"`
+	Status       string              `json:"status"`
+	Class        Coding              `json:"class"`
+	Type         []CodeableConcept   `json:"type,omitempty"`
+	Priority     *CodeableConcept    `json:"priority,omitempty"`
+	Subject      *Reference          `json:"subject,omitempty"`
+	Period       *Period             `json:"period,omitempty"`
+	Length       *Duration           `json:"length,omitempty"`
+	ReasonCode   []CodeableConcept   `json:"reasonCode,omitempty"`
+	Location     []EncounterLocation `json:"location,omitempty"`
+	PartOf       *Reference          `json:"partOf,omitempty"`
+}
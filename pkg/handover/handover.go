@@ -0,0 +1,212 @@
+package handover
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/alerts"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/schema"
+	"github.com/jamieyoung5/kwikmedical-eventstream/pb"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"strings"
+	"time"
+)
+
+// Package summarizes a callout for the receiving hospital. The patient and medical record are nil when the
+// patient has not been identified, and the incident is nil when the call is not part of a declared incident.
+type Package struct {
+	HandoverID    uint
+	GeneratedAt   time.Time
+	Hospital      *pb.RegionalHospital
+	Request       *pb.AmbulanceRequest
+	EmergencyCall *pb.EmergencyCall
+	Incident      *schema.Incident
+	Callouts      []*pb.CallOutDetail
+	Patient       *pb.Patient
+	MedicalRecord *pb.MedicalRecord
+	Entries       *schema.ClinicalEntries
+	Alerts        []alerts.Alert
+}
+
+// packageJson is the JSON form of a Package, with its protobuf messages already written by protojson
+type packageJson struct {
+	HandoverID    uint                    `json:"handover_id"`
+	GeneratedAt   time.Time               `json:"generated_at"`
+	Hospital      json.RawMessage         `json:"hospital"`
+	Request       json.RawMessage         `json:"request"`
+	EmergencyCall json.RawMessage         `json:"emergency_call"`
+	Incident      *schema.Incident        `json:"incident,omitempty"`
+	Callouts      []json.RawMessage       `json:"callouts"`
+	Patient       json.RawMessage         `json:"patient,omitempty"`
+	MedicalRecord json.RawMessage         `json:"medical_record,omitempty"`
+	Entries       *schema.ClinicalEntries `json:"clinical_entries,omitempty"`
+	Alerts        []alerts.Alert          `json:"alerts"`
+}
+
+// MarshalJSON writes the protobuf messages in the package with protojson, so enums, timestamps and durations
+// take their canonical JSON forms rather than the Go struct layout of the generated types.
+func (p *Package) MarshalJSON() ([]byte, error) {
+	out := packageJson{
+		HandoverID:  p.HandoverID,
+		GeneratedAt: p.GeneratedAt,
+		Incident:    p.Incident,
+		Entries:     p.Entries,
+		Alerts:      p.Alerts,
+	}
+
+	var err error
+	if out.Hospital, err = marshalMessage(p.Hospital); err != nil {
+		return nil, err
+	}
+	if out.Request, err = marshalMessage(p.Request); err != nil {
+		return nil, err
+	}
+	if out.EmergencyCall, err = marshalMessage(p.EmergencyCall); err != nil {
+		return nil, err
+	}
+	if out.Patient, err = marshalMessage(p.Patient); err != nil {
+		return nil, err
+	}
+	if out.MedicalRecord, err = marshalMessage(p.MedicalRecord); err != nil {
+		return nil, err
+	}
+	for _, callout := range p.Callouts {
+		message, err := marshalMessage(callout)
+		if err != nil {
+			return nil, err
+		}
+		out.Callouts = append(out.Callouts, message)
+	}
+
+	return json.Marshal(out)
+}
+
+func (p *Package) JSON() ([]byte, error) {
+	return json.MarshalIndent(p, "", "  ")
+}
+
+// Proto returns the package as a protobuf Struct so it can be published on the event stream as is.
+func (p *Package) Proto() (*structpb.Struct, error) {
+	bytes, err := p.MarshalJSON()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal handover package: %w", err)
+	}
+
+	var fields structpb.Struct
+	if err = protojson.Unmarshal(bytes, &fields); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal handover package: %w", err)
+	}
+
+	return &fields, nil
+}
+
+// marshalMessage writes a message with its proto field names, leaving nil messages empty
+func marshalMessage(message proto.Message) (json.RawMessage, error) {
+	if message == nil || !message.ProtoReflect().IsValid() {
+		return nil, nil
+	}
+
+	bytes, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s: %w", message.ProtoReflect().Descriptor().FullName(), err)
+	}
+	return bytes, nil
+}
+
+// Text renders the package as a printable ATMIST handover: Age, Time of incident, Mechanism, Injuries or
+// illness, Signs and Treatment, followed by the patient's alerts, allergies and medications.
+func (p *Package) Text() string {
+	var b strings.Builder
+
+	hospital := "unknown hospital"
+	if p.Hospital != nil {
+		hospital = p.Hospital.Name
+	}
+	fmt.Fprintf(&b, "HANDOVER TO %s\n", strings.ToUpper(hospital))
+	fmt.Fprintf(&b, "Request %d, generated %s\n", p.Request.RequestId, p.GeneratedAt.Format(time.RFC1123))
+	b.WriteString(strings.Repeat("=", 60) + "\n")
+
+	if p.Patient != nil {
+		fmt.Fprintf(&b, "Patient:   %s %s (NHS %s)\n", p.Patient.FirstName, p.Patient.LastName, p.Patient.NhsNumber)
+	} else {
+		b.WriteString("Patient:   not identified\n")
+	}
+
+	fmt.Fprintf(&b, "A - Age:       %s\n", p.age())
+	fmt.Fprintf(&b, "T - Time:      %s\n", p.EmergencyCall.CallTime.AsTime().Format(time.RFC1123))
+	fmt.Fprintf(&b, "M - Mechanism: %s\n", p.mechanism())
+	fmt.Fprintf(&b, "I - Injuries:  %s, severity %s\n", orNone(p.EmergencyCall.MedicalCondition), p.EmergencyCall.Severity)
+
+	var signs, treatment []string
+	for _, callout := range p.Callouts {
+		if callout.Notes != "" {
+			signs = append(signs, callout.Notes)
+		}
+		if callout.ActionTaken != "" {
+			action := callout.ActionTaken
+			if callout.TimeSpent != nil {
+				action += fmt.Sprintf(" (%s)", callout.TimeSpent.AsDuration())
+			}
+			treatment = append(treatment, action)
+		}
+	}
+	fmt.Fprintf(&b, "S - Signs:     %s\n", orNone(strings.Join(signs, "; ")))
+	fmt.Fprintf(&b, "T - Treatment: %s\n", orNone(strings.Join(treatment, "; ")))
+
+	if len(p.Alerts) > 0 {
+		b.WriteString(strings.Repeat("-", 60) + "\nALERTS\n")
+		for _, alert := range p.Alerts {
+			fmt.Fprintf(&b, "  [%s] %s: %s\n", alert.Priority, alert.Title, alert.Message)
+		}
+	}
+
+	if p.MedicalRecord != nil {
+		b.WriteString(strings.Repeat("-", 60) + "\n")
+		fmt.Fprintf(&b, "Allergies:   %s\n", orNone(strings.Join(p.MedicalRecord.Allergies, ", ")))
+		fmt.Fprintf(&b, "Medications: %s\n", orNone(strings.Join(p.MedicalRecord.Medications, ", ")))
+		fmt.Fprintf(&b, "Conditions:  %s\n", orNone(strings.Join(p.MedicalRecord.Conditions, ", ")))
+	}
+
+	return b.String()
+}
+
+// mechanism describes how the patient came to be hurt, which is only known when the call is part of a declared
+// incident. The condition reported on the call describes the injuries themselves.
+func (p *Package) mechanism() string {
+	if p.Incident == nil {
+		return orNone("")
+	}
+
+	mechanism := p.Incident.Name
+	if description := strings.TrimSpace(p.Incident.Description); description != "" {
+		mechanism += ": " + description
+	}
+	return mechanism
+}
+
+func (p *Package) age() string {
+	if p.Patient == nil || len(p.Patient.DateOfBirth) < len("2006-01-02") {
+		return "unknown"
+	}
+
+	born, err := time.Parse("2006-01-02", p.Patient.DateOfBirth[:len("2006-01-02")])
+	if err != nil {
+		return "unknown"
+	}
+
+	at := p.EmergencyCall.CallTime.AsTime()
+	years := at.Year() - born.Year()
+	if at.Month() < born.Month() || (at.Month() == born.Month() && at.Day() < born.Day()) {
+		years--
+	}
+
+	return fmt.Sprintf("%d (born %s)", years, born.Format("2006-01-02"))
+}
+
+func orNone(value string) string {
+	if strings.TrimSpace(value) == "" {
+		return "none recorded"
+	}
+	return value
+}
@@ -0,0 +1,155 @@
+package heatmap
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+type Shape string
+
+const (
+	Square  Shape = "square"
+	Hexagon Shape = "hexagon"
+
+	// metres per degree of latitude, and of longitude at the equator
+	metresPerDegreeLatitude  = 110540.0
+	metresPerDegreeLongitude = 111320.0
+)
+
+// Point is a location with the number of calls made from it
+type Point struct {
+	Latitude  float64
+	Longitude float64
+	Weight    int
+}
+
+// grid projects locations onto a flat plane in metres around a reference latitude, which is accurate enough for
+// the distances a single service covers
+type grid struct {
+	shape       Shape
+	size        float64
+	cosLatitude float64
+}
+
+type cell struct {
+	col, row int
+}
+
+// Aggregate bins the points into grid cells of the given size in metres, which is the side of a square cell or
+// the distance from the centre to a corner of a hexagonal one. The grid is projected around a fixed reference
+// latitude, rather than one taken from the points, so the same location falls in the same cell whatever else is
+// on the map. Only cells containing calls are returned, most calls first.
+func Aggregate(points []Point, shape Shape, cellSize float64, referenceLatitude float64) (*FeatureCollection, error) {
+	if cellSize <= 0 {
+		return nil, errors.New("heatmap cell size must be positive")
+	}
+	if shape != Square && shape != Hexagon {
+		return nil, fmt.Errorf("unknown heatmap cell shape %q", shape)
+	}
+	if referenceLatitude <= -90 || referenceLatitude >= 90 {
+		return nil, fmt.Errorf("heatmap reference latitude %v must be between -90 and 90", referenceLatitude)
+	}
+
+	collection := &FeatureCollection{Type: "FeatureCollection", Features: []Feature{}}
+	if len(points) == 0 {
+		return collection, nil
+	}
+
+	g := grid{
+		shape:       shape,
+		size:        cellSize,
+		cosLatitude: math.Cos(referenceLatitude * math.Pi / 180),
+	}
+
+	counts := map[cell]int{}
+	for _, point := range points {
+		counts[g.cellOf(point)] += point.Weight
+	}
+
+	cells := make([]cell, 0, len(counts))
+	for c := range counts {
+		cells = append(cells, c)
+	}
+	sort.Slice(cells, func(i, j int) bool {
+		if counts[cells[i]] != counts[cells[j]] {
+			return counts[cells[i]] > counts[cells[j]]
+		}
+		if cells[i].row != cells[j].row {
+			return cells[i].row < cells[j].row
+		}
+		return cells[i].col < cells[j].col
+	})
+
+	for _, c := range cells {
+		collection.Features = append(collection.Features, Feature{
+			Type: "Feature",
+			Geometry: Geometry{
+				Type:        "Polygon",
+				Coordinates: [][][2]float64{g.polygon(c)},
+			},
+			Properties: map[string]interface{}{
+				"cell":  fmt.Sprintf("%d:%d", c.col, c.row),
+				"count": counts[c],
+			},
+		})
+	}
+
+	return collection, nil
+}
+
+func (g grid) project(latitude float64, longitude float64) (float64, float64) {
+	return longitude * metresPerDegreeLongitude * g.cosLatitude, latitude * metresPerDegreeLatitude
+}
+
+// unproject returns GeoJSON's [longitude, latitude] order
+func (g grid) unproject(x float64, y float64) [2]float64 {
+	return [2]float64{x / (metresPerDegreeLongitude * g.cosLatitude), y / metresPerDegreeLatitude}
+}
+
+func (g grid) cellOf(point Point) cell {
+	x, y := g.project(point.Latitude, point.Longitude)
+	if g.shape == Square {
+		return cell{col: int(math.Floor(x / g.size)), row: int(math.Floor(y / g.size))}
+	}
+
+	// pointy-top hexagons in axial coordinates, rounded through cube coordinates
+	q := (math.Sqrt(3)/3*x - y/3) / g.size
+	r := (2.0 / 3 * y) / g.size
+	s := -q - r
+
+	rq, rr, rs := math.Round(q), math.Round(r), math.Round(s)
+	dq, dr, ds := math.Abs(rq-q), math.Abs(rr-r), math.Abs(rs-s)
+	if dq > dr && dq > ds {
+		rq = -rr - rs
+	} else if dr > ds {
+		rr = -rq - rs
+	}
+
+	return cell{col: int(rq), row: int(rr)}
+}
+
+// polygon is the closed ring of the cell's corners, anticlockwise as GeoJSON expects
+func (g grid) polygon(c cell) [][2]float64 {
+	if g.shape == Square {
+		x, y := float64(c.col)*g.size, float64(c.row)*g.size
+		return [][2]float64{
+			g.unproject(x, y),
+			g.unproject(x+g.size, y),
+			g.unproject(x+g.size, y+g.size),
+			g.unproject(x, y+g.size),
+			g.unproject(x, y),
+		}
+	}
+
+	centreX := g.size * math.Sqrt(3) * (float64(c.col) + float64(c.row)/2)
+	centreY := g.size * 1.5 * float64(c.row)
+
+	ring := make([][2]float64, 0, 7)
+	for i := 0; i < 6; i++ {
+		angle := math.Pi / 180 * float64(60*i-30)
+		ring = append(ring, g.unproject(centreX+g.size*math.Cos(angle), centreY+g.size*math.Sin(angle)))
+	}
+	return append(ring, ring[0])
+}
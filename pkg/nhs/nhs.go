@@ -0,0 +1,78 @@
+package nhs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const numberLength = 10
+
+var (
+	ErrInvalidLength     = errors.New("nhs number must contain exactly 10 digits")
+	ErrInvalidCharacter  = errors.New("nhs number must only contain digits, spaces or hyphens")
+	ErrInvalidCheckDigit = errors.New("nhs number check digit is invalid")
+)
+
+// Normalize strips the spacing and hyphens callers commonly use when reading out an NHS number
+// ("943 476 5919", "943-476-5919") and returns the bare 10 digit form stored in the database.
+func Normalize(number string) (string, error) {
+	var builder strings.Builder
+	for _, r := range strings.TrimSpace(number) {
+		switch {
+		case r >= '0' && r <= '9':
+			builder.WriteRune(r)
+		case r == ' ' || r == '-':
+			continue
+		default:
+			return "", fmt.Errorf("%w: %q", ErrInvalidCharacter, number)
+		}
+	}
+
+	normalized := builder.String()
+	if len(normalized) != numberLength {
+		return "", fmt.Errorf("%w: %q", ErrInvalidLength, number)
+	}
+
+	return normalized, nil
+}
+
+// Validate normalizes the given NHS number and verifies its Modulus 11 check digit.
+// The normalized number is returned so callers can persist it directly.
+func Validate(number string) (string, error) {
+	normalized, err := Normalize(number)
+	if err != nil {
+		return "", err
+	}
+
+	if checkDigit(normalized) != int(normalized[numberLength-1]-'0') {
+		return "", fmt.Errorf("%w: %q", ErrInvalidCheckDigit, number)
+	}
+
+	return normalized, nil
+}
+
+// IsValid reports whether the given NHS number is well-formed and has a valid check digit.
+func IsValid(number string) bool {
+	_, err := Validate(number)
+	return err == nil
+}
+
+// checkDigit computes the Modulus 11 check digit of a normalized NHS number. The first nine
+// digits are weighted 10 down to 2; a result of 10 can never be valid so -1 is returned.
+func checkDigit(normalized string) int {
+	sum := 0
+	for i := 0; i < numberLength-1; i++ {
+		sum += int(normalized[i]-'0') * (numberLength - i)
+	}
+
+	check := 11 - (sum % 11)
+	switch check {
+	case 11:
+		return 0
+	case 10:
+		return -1
+	default:
+		return check
+	}
+}
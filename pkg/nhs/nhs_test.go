@@ -0,0 +1,63 @@
+package nhs
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		number string
+		want   string
+		err    error
+	}{
+		{name: "bare digits", number: "9434765919", want: "9434765919"},
+		{name: "spaced", number: "943 476 5919", want: "9434765919"},
+		{name: "hyphenated", number: "943-476-5919", want: "9434765919"},
+		{name: "surrounding whitespace", number: "  401 023 2137 ", want: "4010232137"},
+		{name: "remainder of zero gives check digit zero", number: "0000000000", want: "0000000000"},
+		{name: "wrong check digit", number: "9434765918", err: ErrInvalidCheckDigit},
+		{name: "check digit of ten is never valid", number: "0000000060", err: ErrInvalidCheckDigit},
+		{name: "too short", number: "943476591", err: ErrInvalidLength},
+		{name: "too long", number: "94347659190", err: ErrInvalidLength},
+		{name: "empty", number: "", err: ErrInvalidLength},
+		{name: "letters", number: "943476591A", err: ErrInvalidCharacter},
+		{name: "other separators", number: "943.476.5919", err: ErrInvalidCharacter},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Validate(test.number)
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("Validate(%q) error = %v, want %v", test.number, err, test.err)
+				}
+				if IsValid(test.number) {
+					t.Errorf("IsValid(%q) = true, want false", test.number)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Validate(%q) returned error %v", test.number, err)
+			}
+			if got != test.want {
+				t.Errorf("Validate(%q) = %q, want %q", test.number, got, test.want)
+			}
+			if !IsValid(test.number) {
+				t.Errorf("IsValid(%q) = false, want true", test.number)
+			}
+		})
+	}
+}
+
+func TestNormalizeDoesNotCheckDigit(t *testing.T) {
+	got, err := Normalize("943 476 5918")
+	if err != nil {
+		t.Fatalf("Normalize returned error %v", err)
+	}
+	if got != "9434765918" {
+		t.Errorf("Normalize = %q, want %q", got, "9434765918")
+	}
+}
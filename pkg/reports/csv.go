@@ -0,0 +1,86 @@
+package reports
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+// WriteResponseTimesCSV and the other CSV writers write a report with a header row, durations are written in
+// seconds so the files can be loaded straight into a spreadsheet.
+func WriteResponseTimesCSV(w io.Writer, report []ResponseTimes) error {
+	records := [][]string{append([]string{"group"}, durationStatsHeader...)}
+	for _, row := range report {
+		records = append(records, append([]string{row.Group}, row.DurationStats.record()...))
+	}
+	return writeCSV(w, records)
+}
+
+func WriteCallsBySeverityCSV(w io.Writer, report []SeverityCount) error {
+	records := [][]string{{"group", "severity", "calls"}}
+	for _, row := range report {
+		records = append(records, []string{row.Group, string(row.Severity), strconv.Itoa(row.Calls)})
+	}
+	return writeCSV(w, records)
+}
+
+func WriteUtilizationCSV(w io.Writer, report []Utilization) error {
+	records := [][]string{{"group", "on_call_seconds", "available_seconds", "maintenance_seconds", "utilization"}}
+	for _, row := range report {
+		records = append(records, []string{
+			row.Group,
+			formatSeconds(row.OnCall),
+			formatSeconds(row.Available),
+			formatSeconds(row.Maintenance),
+			strconv.FormatFloat(row.Rate(), 'f', 4, 64),
+		})
+	}
+	return writeCSV(w, records)
+}
+
+func WriteHospitalArrivalsCSV(w io.Writer, report []HospitalArrivals) error {
+	records := [][]string{{"group", "hospital_id", "hospital_name", "arrivals"}}
+	for _, row := range report {
+		records = append(records, []string{
+			row.Group,
+			strconv.FormatUint(uint64(row.HospitalID), 10),
+			row.HospitalName,
+			strconv.Itoa(row.Arrivals),
+		})
+	}
+	return writeCSV(w, records)
+}
+
+func WriteTimeSpentCSV(w io.Writer, report []TimeSpent) error {
+	records := [][]string{append([]string{"group"}, durationStatsHeader...)}
+	for _, row := range report {
+		records = append(records, append([]string{row.Group}, row.DurationStats.record()...))
+	}
+	return writeCSV(w, records)
+}
+
+var durationStatsHeader = []string{"count", "mean_seconds", "median_seconds", "p90_seconds", "min_seconds", "max_seconds"}
+
+func (s DurationStats) record() []string {
+	return []string{
+		strconv.Itoa(s.Count),
+		formatSeconds(s.Mean),
+		formatSeconds(s.Median),
+		formatSeconds(s.P90),
+		formatSeconds(s.Min),
+		formatSeconds(s.Max),
+	}
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 1, 64)
+}
+
+func writeCSV(w io.Writer, records [][]string) error {
+	writer := csv.NewWriter(w)
+	if err := writer.WriteAll(records); err != nil {
+		return err
+	}
+	return writer.Error()
+}
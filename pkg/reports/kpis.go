@@ -0,0 +1,286 @@
+package reports
+
+import (
+	"database/sql"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/schema"
+	"time"
+)
+
+// durationStats summarises the secs column of a grouped query
+const durationStats = `count(*),
+	avg(secs),
+	percentile_cont(0.5) WITHIN GROUP (ORDER BY secs),
+	percentile_cont(0.9) WITHIN GROUP (ORDER BY secs),
+	min(secs),
+	max(secs)`
+
+type DurationStats struct {
+	Count  int
+	Mean   time.Duration
+	Median time.Duration
+	P90    time.Duration
+	Min    time.Duration
+	Max    time.Duration
+}
+
+func scanDurationStats(rows *sql.Rows, group *string) (DurationStats, error) {
+	var (
+		stats                     DurationStats
+		mean, median, p90, lo, hi sql.NullFloat64
+	)
+	if err := rows.Scan(group, &stats.Count, &mean, &median, &p90, &lo, &hi); err != nil {
+		return stats, err
+	}
+
+	stats.Mean, stats.Median, stats.P90 = seconds(mean), seconds(median), seconds(p90)
+	stats.Min, stats.Max = seconds(lo), seconds(hi)
+
+	return stats, nil
+}
+
+// ResponseTimes are the times from a call being made to an ambulance arriving on scene
+type ResponseTimes struct {
+	Group string
+	DurationStats
+}
+
+type SeverityCount struct {
+	Group    string
+	Severity schema.InjurySeverity
+	Calls    int
+}
+
+// Utilization is how long the ambulances of a group spent in each status
+type Utilization struct {
+	Group       string
+	OnCall      time.Duration
+	Available   time.Duration
+	Maintenance time.Duration
+}
+
+// Rate is the share of the ambulances' time spent on call
+func (u Utilization) Rate() float64 {
+	total := u.OnCall + u.Available + u.Maintenance
+	if total == 0 {
+		return 0
+	}
+	return float64(u.OnCall) / float64(total)
+}
+
+// HospitalArrivals counts the patients handed over to a hospital
+type HospitalArrivals struct {
+	Group        string
+	HospitalID   uint
+	HospitalName string
+	Arrivals     int
+}
+
+// TimeSpent is the distribution of the time ambulance crews spent on callouts
+type TimeSpent struct {
+	Group string
+	DurationStats
+}
+
+// ResponseTimes reports the response times of calls made in the period that an ambulance has arrived at. Calls
+// are placed in the region of the hospital their first ambulance request was made to.
+func (r *Reporter) ResponseTimes(period Period) ([]ResponseTimes, error) {
+	rows, err := r.query(period, `
+	SELECT `+period.groupExpression("event_time", "region")+` AS grp, `+durationStats+`
+	FROM (
+		SELECT emergency_call_timings.call_time AS event_time,
+			regional_hospitals.name AS region,
+			extract(epoch FROM emergency_call_timings.response_time) AS secs
+		FROM emergency_call_timings
+		LEFT JOIN LATERAL (
+			SELECT hospital_id FROM ambulance_requests
+			WHERE ambulance_requests.emergency_call_id = emergency_call_timings.call_id
+			ORDER BY created_at ASC
+			LIMIT 1
+		) first_request ON true
+		LEFT JOIN regional_hospitals ON regional_hospitals.hospital_id = first_request.hospital_id
+		WHERE emergency_call_timings.response_time IS NOT NULL
+			AND emergency_call_timings.call_time >= $1 AND emergency_call_timings.call_time < $2
+	) timings
+	GROUP BY grp
+	ORDER BY grp`, period.From, period.To)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var report []ResponseTimes
+	for rows.Next() {
+		var row ResponseTimes
+		if row.DurationStats, err = scanDurationStats(rows, &row.Group); err != nil {
+			return nil, err
+		}
+		report = append(report, row)
+	}
+
+	return report, rows.Err()
+}
+
+func (r *Reporter) CallsBySeverity(period Period) ([]SeverityCount, error) {
+	rows, err := r.query(period, `
+	SELECT `+period.groupExpression("event_time", "region")+` AS grp, severity, count(*)
+	FROM (
+		SELECT emergency_calls.call_time AS event_time,
+			regional_hospitals.name AS region,
+			coalesce(emergency_calls.severity, 'UNKNOWN_INJURY_SEVERITY') AS severity
+		FROM emergency_calls
+		LEFT JOIN LATERAL (
+			SELECT hospital_id FROM ambulance_requests
+			WHERE ambulance_requests.emergency_call_id = emergency_calls.call_id
+			ORDER BY created_at ASC
+			LIMIT 1
+		) first_request ON true
+		LEFT JOIN regional_hospitals ON regional_hospitals.hospital_id = first_request.hospital_id
+		WHERE emergency_calls.call_time >= $1 AND emergency_calls.call_time < $2
+	) calls
+	GROUP BY grp, severity
+	ORDER BY grp, severity`, period.From, period.To)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var report []SeverityCount
+	for rows.Next() {
+		var row SeverityCount
+		if err = rows.Scan(&row.Group, &row.Severity, &row.Calls); err != nil {
+			return nil, err
+		}
+		report = append(report, row)
+	}
+
+	return report, rows.Err()
+}
+
+// AmbulanceUtilization reports how long ambulances spent in each status during the period. Time is split across
+// day and week groups where a status spans more than one, and ambulances are placed in the region of their
+// regional hospital.
+func (r *Reporter) AmbulanceUtilization(period Period) ([]Utilization, error) {
+	buckets := `SELECT $1::timestamp AS bucket_start, $2::timestamp AS bucket_end`
+	if period.Grouping != ByRegion {
+		step := "INTERVAL '1 " + string(period.Grouping) + "'"
+		buckets = `SELECT greatest(bucket, $1::timestamp) AS bucket_start, least(bucket + ` + step + `, $2::timestamp) AS bucket_end
+		FROM generate_series(date_trunc('` + string(period.Grouping) + `', $1::timestamp), $2::timestamp, ` + step + `) bucket
+		WHERE bucket < $2::timestamp`
+	}
+
+	rows, err := r.query(period, `
+	WITH buckets AS (`+buckets+`)
+	SELECT `+period.groupExpression("event_time", "region")+` AS grp, status, sum(secs)
+	FROM (
+		SELECT buckets.bucket_start AS event_time,
+			regional_hospitals.name AS region,
+			ambulance_status_history.status,
+			extract(epoch FROM
+				least(coalesce(ambulance_status_history.ended_at, LOCALTIMESTAMP), buckets.bucket_end) -
+				greatest(ambulance_status_history.started_at, buckets.bucket_start)
+			) AS secs
+		FROM ambulance_status_history
+		INNER JOIN buckets ON ambulance_status_history.started_at < buckets.bucket_end
+			AND coalesce(ambulance_status_history.ended_at, LOCALTIMESTAMP) > buckets.bucket_start
+		INNER JOIN ambulances ON ambulances.ambulance_id = ambulance_status_history.ambulance_id
+		LEFT JOIN regional_hospitals ON regional_hospitals.hospital_id = ambulances.regional_hospital_id
+	) periods
+	GROUP BY grp, status
+	ORDER BY grp`, period.From, period.To)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var report []Utilization
+	for rows.Next() {
+		var (
+			group  string
+			status schema.AmbulanceStatus
+			secs   sql.NullFloat64
+		)
+		if err = rows.Scan(&group, &status, &secs); err != nil {
+			return nil, err
+		}
+
+		if len(report) == 0 || report[len(report)-1].Group != group {
+			report = append(report, Utilization{Group: group})
+		}
+		row := &report[len(report)-1]
+		switch status {
+		case schema.OnCall:
+			row.OnCall += seconds(secs)
+		case schema.Available:
+			row.Available += seconds(secs)
+		case schema.Maintenance:
+			row.Maintenance += seconds(secs)
+		}
+	}
+
+	return report, rows.Err()
+}
+
+func (r *Reporter) HospitalArrivals(period Period) ([]HospitalArrivals, error) {
+	rows, err := r.query(period, `
+	SELECT `+period.groupExpression("event_time", "region")+` AS grp, hospital_id, hospital_name, count(*)
+	FROM (
+		SELECT hospital_handovers.handed_over_at AS event_time,
+			regional_hospitals.name AS region,
+			hospital_handovers.hospital_id,
+			regional_hospitals.name AS hospital_name
+		FROM hospital_handovers
+		INNER JOIN regional_hospitals ON regional_hospitals.hospital_id = hospital_handovers.hospital_id
+		WHERE hospital_handovers.handed_over_at >= $1 AND hospital_handovers.handed_over_at < $2
+	) arrivals
+	GROUP BY grp, hospital_id, hospital_name
+	ORDER BY grp, hospital_name`, period.From, period.To)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var report []HospitalArrivals
+	for rows.Next() {
+		var row HospitalArrivals
+		if err = rows.Scan(&row.Group, &row.HospitalID, &row.HospitalName, &row.Arrivals); err != nil {
+			return nil, err
+		}
+		report = append(report, row)
+	}
+
+	return report, rows.Err()
+}
+
+// CalloutTimeSpent reports the distribution of time spent on callouts recorded in the period, placing callouts in
+// the region of their ambulance's regional hospital.
+func (r *Reporter) CalloutTimeSpent(period Period) ([]TimeSpent, error) {
+	rows, err := r.query(period, `
+	SELECT `+period.groupExpression("event_time", "region")+` AS grp, `+durationStats+`
+	FROM (
+		SELECT call_out_details.created_at AS event_time,
+			regional_hospitals.name AS region,
+			extract(epoch FROM call_out_details.time_spent) AS secs
+		FROM call_out_details
+		LEFT JOIN ambulances ON ambulances.ambulance_id = call_out_details.ambulance_id
+		LEFT JOIN regional_hospitals ON regional_hospitals.hospital_id = ambulances.regional_hospital_id
+		WHERE call_out_details.time_spent IS NOT NULL
+			AND call_out_details.created_at >= $1 AND call_out_details.created_at < $2
+	) callouts
+	GROUP BY grp
+	ORDER BY grp`, period.From, period.To)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var report []TimeSpent
+	for rows.Next() {
+		var row TimeSpent
+		if row.DurationStats, err = scanDurationStats(rows, &row.Group); err != nil {
+			return nil, err
+		}
+		report = append(report, row)
+	}
+
+	return report, rows.Err()
+}
@@ -0,0 +1,82 @@
+package reports
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/client"
+	"time"
+)
+
+type Grouping string
+
+const (
+	ByDay    Grouping = "day"
+	ByWeek   Grouping = "week"
+	ByRegion Grouping = "region"
+
+	// unassignedRegion groups rows that cannot be tied to a regional hospital
+	unassignedRegion = "Unassigned"
+)
+
+// Period is the time range a report covers and how its rows are grouped. Day and week groups are labelled
+// "2006-01-02" and ISO weeks "2006-W01", regions are labelled with the name of their regional hospital.
+type Period struct {
+	From     time.Time
+	To       time.Time
+	Grouping Grouping
+}
+
+func (p Period) validate() error {
+	if !p.From.Before(p.To) {
+		return errors.New("a report period must start before it ends")
+	}
+	switch p.Grouping {
+	case ByDay, ByWeek, ByRegion:
+		return nil
+	default:
+		return fmt.Errorf("unknown report grouping %q", p.Grouping)
+	}
+}
+
+// groupExpression labels a row by the period's grouping, using the time column for day and week groupings and
+// the region column for region groupings
+func (p Period) groupExpression(timeColumn string, regionColumn string) string {
+	switch p.Grouping {
+	case ByDay:
+		return "to_char(date_trunc('day', " + timeColumn + "), 'YYYY-MM-DD')"
+	case ByWeek:
+		return "to_char(date_trunc('week', " + timeColumn + "), 'IYYY-\"W\"IW')"
+	default:
+		return "coalesce(" + regionColumn + ", '" + unassignedRegion + "')"
+	}
+}
+
+// Reporter produces the operational KPI reports
+type Reporter struct {
+	db *client.KwikMedicalDBClient
+}
+
+func NewReporter(db *client.KwikMedicalDBClient) *Reporter {
+	return &Reporter{db: db}
+}
+
+func (r *Reporter) query(period Period, query string, args ...any) (*sql.Rows, error) {
+	if err := period.validate(); err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run report: %w", err)
+	}
+
+	return rows, nil
+}
+
+func seconds(value sql.NullFloat64) time.Duration {
+	if !value.Valid {
+		return 0
+	}
+	return time.Duration(value.Float64 * float64(time.Second))
+}
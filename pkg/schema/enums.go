@@ -1,10 +1,22 @@
 package schema
 
+import "errors"
+
+//go:generate go run ../../internal/enumgen -input enums.go -output enums_pb.go InjurySeverity EmergencyCallStatus AmbulanceStatus StaffRole RequestStatus
+
+// ErrUnknownEnumValue is returned when a value has no counterpart in the protobuf or database enum
+var ErrUnknownEnumValue = errors.New("unknown enum value")
+
 type EmergencyCallStatus string
 type AmbulanceStatus string
 type InjurySeverity string
 type StaffRole string
 type RequestStatus string
+type DuplicateStatus string
+type AllergySeverity string
+type IncidentStatus string
+type IncidentEventType string
+type MaintenanceStatus string
 
 const (
 	UnknownEmergency EmergencyCallStatus = "UNKNOWN_EMERGENCY_CALL_STATUS"
@@ -35,4 +47,28 @@ const (
 	ReqAccepted  RequestStatus = "ACCEPTED"
 	ReqRejected  RequestStatus = "REJECTED"
 	ReqCompleted RequestStatus = "COMPLETED"
+
+	DuplicateProposed  DuplicateStatus = "PROPOSED"
+	DuplicateMerged    DuplicateStatus = "MERGED"
+	DuplicateDismissed DuplicateStatus = "DISMISSED"
+
+	UnknownAllergySeverity AllergySeverity = "UNKNOWN_ALLERGY_SEVERITY"
+	AllergyMild            AllergySeverity = "MILD"
+	AllergyModerate        AllergySeverity = "MODERATE"
+	AllergySevere          AllergySeverity = "SEVERE"
+	AllergyLifeThreatening AllergySeverity = "LIFE_THREATENING"
+
+	IncidentDeclared  IncidentStatus = "DECLARED"
+	IncidentStoodDown IncidentStatus = "STOOD_DOWN"
+
+	IncidentDeclaredEvent     IncidentEventType = "DECLARED"
+	IncidentCallAttached      IncidentEventType = "CALL_ATTACHED"
+	IncidentAmbulanceAssigned IncidentEventType = "AMBULANCE_ASSIGNED"
+	IncidentStoodDownEvent    IncidentEventType = "STOOD_DOWN"
+
+	MaintenanceScheduled  MaintenanceStatus = "SCHEDULED"
+	MaintenanceDeferred   MaintenanceStatus = "DEFERRED"
+	MaintenanceInProgress MaintenanceStatus = "IN_PROGRESS"
+	MaintenanceCompleted  MaintenanceStatus = "COMPLETED"
+	MaintenanceCancelled  MaintenanceStatus = "CANCELLED"
 )
@@ -1,49 +1,228 @@
 package schema
 
 import (
+	"errors"
+	"github.com/jamieyoung5/kwikmedical-db-lib/pkg/nhs"
 	pbSchema "github.com/jamieyoung5/kwikmedical-eventstream/pb"
+	"github.com/lib/pq"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"time"
 )
 
-func EmergencyCallPbToGorm(call *pbSchema.EmergencyCall) EmergencyCall {
-	patientId := uint(call.PatientId)
+func EmergencyCallPbToGorm(call *pbSchema.EmergencyCall) (EmergencyCall, error) {
+	if call == nil {
+		return EmergencyCall{}, errors.New("emergency call is nil")
+	}
+
+	// callers frequently do not know the patient's NHS number, so it is only validated when given
+	nhsNumber := call.NhsNumber
+	if nhsNumber != "" {
+		var err error
+		if nhsNumber, err = nhs.Validate(nhsNumber); err != nil {
+			return EmergencyCall{}, err
+		}
+	}
+
+	severity, err := InjurySeverityFromPb(call.Severity)
+	if err != nil {
+		return EmergencyCall{}, err
+	}
+	status, err := EmergencyCallStatusFromPb(call.Status)
+	if err != nil {
+		return EmergencyCall{}, err
+	}
 
 	return EmergencyCall{
-		CallID:           uint(call.CallId),
-		PatientID:        &patientId,
-		NHSNumber:        call.NhsNumber,
+		CallID: uint(call.CallId),
+		// calls are often made before the patient has been identified, they are linked later on
+		PatientID:        idFromPb(call.PatientId),
+		NHSNumber:        nhsNumber,
 		CallerName:       call.CallerName,
 		CallerPhone:      call.CallerPhone,
-		CallTime:         call.CallTime.AsTime(),
+		CallTime:         timestampFromPb(call.CallTime),
 		MedicalCondition: call.MedicalCondition,
 		Location:         LocationFromPb(call.Location),
-		Severity:         InjurySeverity(pbSchema.InjurySeverity_name[int32(call.Severity)]),
-		Status:           EmergencyCallStatus(pbSchema.EmergencyCallStatus_name[int32(call.Status)]),
-	}
+		Severity:         severity,
+		Status:           status,
+	}, nil
 }
 
 func CalloutDetailPbToGorm(callout *pbSchema.CallOutDetail) CallOutDetails {
+	if callout == nil {
+		return CallOutDetails{}
+	}
+
 	return CallOutDetails{
 		DetailID:    uint(callout.DetailId),
 		CallID:      uint(callout.CallId),
 		AmbulanceID: uint(callout.AmbulanceId),
 		ActionTaken: callout.ActionTaken,
-		TimeSpent:   callout.TimeSpent.String(),
+		TimeSpent:   IntervalFromPb(callout.TimeSpent),
 		Notes:       callout.Notes,
-		CreatedAt:   callout.CreatedAt.AsTime(),
+		CreatedAt:   timestampFromPb(callout.CreatedAt),
 	}
 }
 
-func AmbulanceRequestPbToGorm(request *pbSchema.AmbulanceRequest) AmbulanceRequest {
-	hospitalId := uint(request.HospitalId)
+func AmbulanceRequestPbToGorm(request *pbSchema.AmbulanceRequest) (AmbulanceRequest, error) {
+	if request == nil {
+		return AmbulanceRequest{}, errors.New("ambulance request is nil")
+	}
+
+	severity, err := InjurySeverityFromPb(request.Severity)
+	if err != nil {
+		return AmbulanceRequest{}, err
+	}
+	status, err := RequestStatusFromPb(request.Status)
+	if err != nil {
+		return AmbulanceRequest{}, err
+	}
 
 	return AmbulanceRequest{
 		RequestID:       uint(request.RequestId),
-		HospitalID:      &hospitalId,
+		AmbulanceID:     idFromPb(request.AmbulanceId),
+		HospitalID:      idFromPb(request.HospitalId),
 		EmergencyCallID: uint(request.EmergencyCallId),
-		Severity:        InjurySeverity(pbSchema.InjurySeverity_name[int32(request.Severity)]),
+		Severity:        severity,
 		Location:        LocationFromPb(request.Location),
-		Status:          RequestStatus(pbSchema.RequestStatus_name[int32(request.Status)]),
-		CreatedAt:       request.CreatedAt.AsTime(),
-		UpdatedAt:       request.UpdatedAt.AsTime(),
+		Status:          status,
+		CreatedAt:       timestampFromPb(request.CreatedAt),
+		UpdatedAt:       timestampFromPb(request.UpdatedAt),
+	}, nil
+}
+
+func PatientPbToGorm(patient *pbSchema.Patient) (Patient, error) {
+	if patient == nil {
+		return Patient{}, errors.New("patient is nil")
+	}
+
+	nhsNumber, err := nhs.Validate(patient.NhsNumber)
+	if err != nil {
+		return Patient{}, err
+	}
+
+	return Patient{
+		PatientID:   uint(patient.PatientId),
+		NHSNumber:   nhsNumber,
+		FirstName:   patient.FirstName,
+		LastName:    patient.LastName,
+		DateOfBirth: patient.DateOfBirth,
+		Address:     patient.Address,
+		PhoneNumber: patient.PhoneNumber,
+		Email:       patient.Email,
+		CreatedAt:   timestampFromPb(patient.CreatedAt),
+	}, nil
+}
+
+// MedicalRecordPbToGorm splits a medical record into the record and the callouts that are stored separately
+func MedicalRecordPbToGorm(record *pbSchema.MedicalRecord) (MedicalRecord, []CallOutDetails) {
+	if record == nil {
+		return MedicalRecord{}, nil
+	}
+
+	var callouts []CallOutDetails
+	for _, callout := range record.Callouts {
+		if callout != nil {
+			callouts = append(callouts, CalloutDetailPbToGorm(callout))
+		}
+	}
+
+	return MedicalRecord{
+		RecordID:    uint(record.RecordId),
+		PatientID:   uint(record.PatientId),
+		Conditions:  pq.StringArray(record.Conditions),
+		Medications: pq.StringArray(record.Medications),
+		Allergies:   pq.StringArray(record.Allergies),
+		Notes:       pq.StringArray(record.Notes),
+		LastUpdated: timestampFromPb(record.LastUpdated),
+	}, callouts
+}
+
+func RegionalHospitalPbToGorm(hospital *pbSchema.RegionalHospital) RegionalHospital {
+	if hospital == nil {
+		return RegionalHospital{}
+	}
+
+	return RegionalHospital{
+		HospitalID:  uint(hospital.HospitalId),
+		Name:        hospital.Name,
+		Address:     hospital.Address,
+		PhoneNumber: hospital.PhoneNumber,
+		Email:       hospital.Email,
+		Location:    LocationFromPb(hospital.Location),
+		Capacity:    int(hospital.Capacity),
+		CreatedAt:   timestampFromPb(hospital.CreatedAt),
+	}
+}
+
+func AmbulancePbToGorm(ambulance *pbSchema.Ambulance) (Ambulance, error) {
+	if ambulance == nil {
+		return Ambulance{}, errors.New("ambulance is nil")
+	}
+
+	status, err := AmbulanceStatusFromPb(ambulance.Status)
+	if err != nil {
+		return Ambulance{}, err
+	}
+
+	return Ambulance{
+		AmbulanceID:        uint(ambulance.AmbulanceId),
+		AmbulanceNumber:    ambulance.AmbulanceNumber,
+		CurrentLocation:    LocationFromPb(ambulance.CurrentLocation),
+		Status:             status,
+		RegionalHospitalID: idFromPb(ambulance.RegionalHospitalId),
+	}, nil
+}
+
+func AmbulanceStaffPbToGorm(staff *pbSchema.AmbulanceStaff) (AmbulanceStaff, error) {
+	if staff == nil {
+		return AmbulanceStaff{}, errors.New("ambulance staff is nil")
+	}
+
+	role, err := StaffRoleFromPb(staff.Role)
+	if err != nil {
+		return AmbulanceStaff{}, err
+	}
+
+	return AmbulanceStaff{
+		StaffID:     uint(staff.StaffId),
+		FirstName:   staff.FirstName,
+		LastName:    staff.LastName,
+		PhoneNumber: staff.PhoneNumber,
+		Email:       staff.Email,
+		Role:        role,
+		AmbulanceID: idFromPb(staff.AmbulanceId),
+		IsActive:    staff.IsActive,
+	}, nil
+}
+
+// idFromPb maps protobuf's unset 0 to a nil foreign key
+func idFromPb(id int32) *uint {
+	if id == 0 {
+		return nil
+	}
+	value := uint(id)
+	return &value
+}
+
+func idToPb(id *uint) int32 {
+	if id == nil {
+		return 0
+	}
+	return int32(*id)
+}
+
+// timestampFromPb maps an unset timestamp to the zero time rather than the unix epoch, so database defaults
+// still apply
+func timestampFromPb(timestamp *timestamppb.Timestamp) time.Time {
+	if timestamp == nil {
+		return time.Time{}
+	}
+	return timestamp.AsTime()
+}
+
+func timestampToPb(timestamp time.Time) *timestamppb.Timestamp {
+	if timestamp.IsZero() {
+		return nil
 	}
+	return timestamppb.New(timestamp)
 }
@@ -0,0 +1,404 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+	pbSchema "github.com/jamieyoung5/kwikmedical-eventstream/pb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+// the converters are checked with random messages, each of which round-trips through its gorm model unchanged.
+// IDs, timestamps and durations are left unset a quarter of the time so the nil and zero mappings are covered.
+
+type emergencyCallPb struct{ *pbSchema.EmergencyCall }
+type calloutPb struct{ *pbSchema.CallOutDetail }
+type ambulanceRequestPb struct{ *pbSchema.AmbulanceRequest }
+type patientPb struct{ *pbSchema.Patient }
+type medicalRecordPb struct{ *pbSchema.MedicalRecord }
+type regionalHospitalPb struct{ *pbSchema.RegionalHospital }
+type ambulancePb struct{ *pbSchema.Ambulance }
+type ambulanceStaffPb struct{ *pbSchema.AmbulanceStaff }
+
+func (emergencyCallPb) Generate(r *rand.Rand, _ int) reflect.Value {
+	nhsNumber := ""
+	if r.Intn(2) == 0 {
+		nhsNumber = randomNhsNumber(r)
+	}
+
+	return reflect.ValueOf(emergencyCallPb{&pbSchema.EmergencyCall{
+		CallId:           randomId(r),
+		PatientId:        randomId(r),
+		NhsNumber:        nhsNumber,
+		CallerName:       randomString(r),
+		CallerPhone:      randomString(r),
+		CallTime:         randomTimestamp(r),
+		MedicalCondition: randomString(r),
+		Location:         randomLocation(r),
+		Severity:         randomEnum(r, InjurySeverityValues),
+		Status:           randomEnum(r, EmergencyCallStatusValues),
+	}})
+}
+
+func (calloutPb) Generate(r *rand.Rand, _ int) reflect.Value {
+	return reflect.ValueOf(calloutPb{randomCallout(r)})
+}
+
+func (ambulanceRequestPb) Generate(r *rand.Rand, _ int) reflect.Value {
+	return reflect.ValueOf(ambulanceRequestPb{&pbSchema.AmbulanceRequest{
+		RequestId:       randomId(r),
+		AmbulanceId:     randomId(r),
+		HospitalId:      randomId(r),
+		EmergencyCallId: randomId(r),
+		Severity:        randomEnum(r, InjurySeverityValues),
+		Location:        randomLocation(r),
+		Status:          randomEnum(r, RequestStatusValues),
+		CreatedAt:       randomTimestamp(r),
+		UpdatedAt:       randomTimestamp(r),
+	}})
+}
+
+func (patientPb) Generate(r *rand.Rand, _ int) reflect.Value {
+	return reflect.ValueOf(patientPb{&pbSchema.Patient{
+		PatientId:   randomId(r),
+		NhsNumber:   randomNhsNumber(r),
+		FirstName:   randomString(r),
+		LastName:    randomString(r),
+		DateOfBirth: randomString(r),
+		Address:     randomString(r),
+		PhoneNumber: randomString(r),
+		Email:       randomString(r),
+		CreatedAt:   randomTimestamp(r),
+	}})
+}
+
+func (medicalRecordPb) Generate(r *rand.Rand, _ int) reflect.Value {
+	var callouts []*pbSchema.CallOutDetail
+	for n := r.Intn(4); n > 0; n-- {
+		callouts = append(callouts, randomCallout(r))
+	}
+
+	return reflect.ValueOf(medicalRecordPb{&pbSchema.MedicalRecord{
+		RecordId:    randomId(r),
+		PatientId:   randomId(r),
+		Callouts:    callouts,
+		Conditions:  randomStrings(r),
+		Medications: randomStrings(r),
+		Allergies:   randomStrings(r),
+		Notes:       randomStrings(r),
+		LastUpdated: randomTimestamp(r),
+	}})
+}
+
+func (regionalHospitalPb) Generate(r *rand.Rand, _ int) reflect.Value {
+	return reflect.ValueOf(regionalHospitalPb{&pbSchema.RegionalHospital{
+		HospitalId:  randomId(r),
+		Name:        randomString(r),
+		Address:     randomString(r),
+		PhoneNumber: randomString(r),
+		Email:       randomString(r),
+		Location:    randomLocation(r),
+		Capacity:    r.Int31(),
+		CreatedAt:   randomTimestamp(r),
+	}})
+}
+
+func (ambulancePb) Generate(r *rand.Rand, _ int) reflect.Value {
+	return reflect.ValueOf(ambulancePb{&pbSchema.Ambulance{
+		AmbulanceId:        randomId(r),
+		AmbulanceNumber:    randomString(r),
+		CurrentLocation:    randomLocation(r),
+		Status:             randomEnum(r, AmbulanceStatusValues),
+		RegionalHospitalId: randomId(r),
+	}})
+}
+
+func (ambulanceStaffPb) Generate(r *rand.Rand, _ int) reflect.Value {
+	return reflect.ValueOf(ambulanceStaffPb{&pbSchema.AmbulanceStaff{
+		StaffId:     randomId(r),
+		FirstName:   randomString(r),
+		LastName:    randomString(r),
+		PhoneNumber: randomString(r),
+		Email:       randomString(r),
+		Role:        randomEnum(r, StaffRoleValues),
+		AmbulanceId: randomId(r),
+		IsActive:    r.Intn(2) == 0,
+	}})
+}
+
+func TestEmergencyCallRoundTrip(t *testing.T) {
+	checkRoundTrip(t, func(in emergencyCallPb) (proto.Message, proto.Message, error) {
+		call, err := EmergencyCallPbToGorm(in.EmergencyCall)
+		if err != nil {
+			return nil, nil, err
+		}
+		out, err := call.ToPb()
+		return in.EmergencyCall, out, err
+	})
+}
+
+func TestCalloutDetailRoundTrip(t *testing.T) {
+	checkRoundTrip(t, func(in calloutPb) (proto.Message, proto.Message, error) {
+		callout := CalloutDetailPbToGorm(in.CallOutDetail)
+		return in.CallOutDetail, callout.ToPb(), nil
+	})
+}
+
+func TestAmbulanceRequestRoundTrip(t *testing.T) {
+	checkRoundTrip(t, func(in ambulanceRequestPb) (proto.Message, proto.Message, error) {
+		request, err := AmbulanceRequestPbToGorm(in.AmbulanceRequest)
+		if err != nil {
+			return nil, nil, err
+		}
+		out, err := request.ToPb()
+		return in.AmbulanceRequest, out, err
+	})
+}
+
+func TestPatientRoundTrip(t *testing.T) {
+	checkRoundTrip(t, func(in patientPb) (proto.Message, proto.Message, error) {
+		patient, err := PatientPbToGorm(in.Patient)
+		if err != nil {
+			return nil, nil, err
+		}
+		return in.Patient, patient.ToPb(), nil
+	})
+}
+
+func TestMedicalRecordRoundTrip(t *testing.T) {
+	checkRoundTrip(t, func(in medicalRecordPb) (proto.Message, proto.Message, error) {
+		record, callouts := MedicalRecordPbToGorm(in.MedicalRecord)
+		return in.MedicalRecord, record.ToPb(callouts), nil
+	})
+}
+
+func TestRegionalHospitalRoundTrip(t *testing.T) {
+	checkRoundTrip(t, func(in regionalHospitalPb) (proto.Message, proto.Message, error) {
+		hospital := RegionalHospitalPbToGorm(in.RegionalHospital)
+		return in.RegionalHospital, hospital.ToPb(), nil
+	})
+}
+
+func TestAmbulanceRoundTrip(t *testing.T) {
+	checkRoundTrip(t, func(in ambulancePb) (proto.Message, proto.Message, error) {
+		ambulance, err := AmbulancePbToGorm(in.Ambulance)
+		if err != nil {
+			return nil, nil, err
+		}
+		out, err := ambulance.ToPb()
+		return in.Ambulance, out, err
+	})
+}
+
+func TestAmbulanceStaffRoundTrip(t *testing.T) {
+	checkRoundTrip(t, func(in ambulanceStaffPb) (proto.Message, proto.Message, error) {
+		staff, err := AmbulanceStaffPbToGorm(in.AmbulanceStaff)
+		if err != nil {
+			return nil, nil, err
+		}
+		out, err := staff.ToPb()
+		return in.AmbulanceStaff, out, err
+	})
+}
+
+func TestUnsetFieldsMapToNil(t *testing.T) {
+	call, err := EmergencyCallPbToGorm(&pbSchema.EmergencyCall{})
+	if err != nil {
+		t.Fatalf("EmergencyCallPbToGorm returned error %v", err)
+	}
+	if call.PatientID != nil {
+		t.Errorf("PatientID = %d, want nil for an unset id", *call.PatientID)
+	}
+	if !call.CallTime.IsZero() {
+		t.Errorf("CallTime = %s, want the zero time for an unset timestamp", call.CallTime)
+	}
+
+	callout := CalloutDetailPbToGorm(&pbSchema.CallOutDetail{})
+	if callout.TimeSpent != nil {
+		t.Errorf("TimeSpent = %s, want nil for an unset duration", callout.TimeSpent)
+	}
+	if out := callout.ToPb(); out.TimeSpent != nil || out.CreatedAt != nil {
+		t.Errorf("ToPb = %+v, want an unset TimeSpent and CreatedAt", out)
+	}
+
+	if location := LocationFromPb(nil); location != (Location{}) {
+		t.Errorf("LocationFromPb(nil) = %+v, want the zero location", location)
+	}
+}
+
+func TestNilMessages(t *testing.T) {
+	nilMessages := map[string]func() error{
+		"emergency call": func() error {
+			_, err := EmergencyCallPbToGorm(nil)
+			return err
+		},
+		"ambulance request": func() error {
+			_, err := AmbulanceRequestPbToGorm(nil)
+			return err
+		},
+		"patient": func() error {
+			_, err := PatientPbToGorm(nil)
+			return err
+		},
+		"ambulance": func() error {
+			_, err := AmbulancePbToGorm(nil)
+			return err
+		},
+		"ambulance staff": func() error {
+			_, err := AmbulanceStaffPbToGorm(nil)
+			return err
+		},
+	}
+	for name, convert := range nilMessages {
+		if err := convert(); err == nil {
+			t.Errorf("converting a nil %s returned no error", name)
+		}
+	}
+
+	if callout := CalloutDetailPbToGorm(nil); !reflect.DeepEqual(callout, CallOutDetails{}) {
+		t.Errorf("CalloutDetailPbToGorm(nil) = %+v, want the zero callout", callout)
+	}
+	if record, callouts := MedicalRecordPbToGorm(nil); !reflect.DeepEqual(record, MedicalRecord{}) || callouts != nil {
+		t.Errorf("MedicalRecordPbToGorm(nil) = %+v, %v, want the zero record", record, callouts)
+	}
+	if hospital := RegionalHospitalPbToGorm(nil); hospital != (RegionalHospital{}) {
+		t.Errorf("RegionalHospitalPbToGorm(nil) = %+v, want the zero hospital", hospital)
+	}
+	if duration := IntervalFromPb(nil); duration != nil {
+		t.Errorf("IntervalFromPb(nil) = %s, want nil", duration)
+	}
+}
+
+func TestUnknownEnumValues(t *testing.T) {
+	if _, err := EmergencyCallPbToGorm(&pbSchema.EmergencyCall{Severity: 99}); !errors.Is(err, ErrUnknownEnumValue) {
+		t.Errorf("EmergencyCallPbToGorm with an unknown severity returned %v, want %v", err, ErrUnknownEnumValue)
+	}
+	if _, err := (&AmbulanceRequest{Severity: Low, Status: "Accepted"}).ToPb(); !errors.Is(err, ErrUnknownEnumValue) {
+		t.Errorf("ToPb with an unknown status returned %v, want %v", err, ErrUnknownEnumValue)
+	}
+	if _, err := (&AmbulanceStaff{Role: "Paramedic"}).ToPb(); !errors.Is(err, ErrUnknownEnumValue) {
+		t.Errorf("ToPb with an unknown role returned %v, want %v", err, ErrUnknownEnumValue)
+	}
+}
+
+// checkRoundTrip checks that the message the generator made comes back unchanged from its gorm model
+func checkRoundTrip[T any](t *testing.T, roundTrip func(T) (proto.Message, proto.Message, error)) {
+	t.Helper()
+
+	property := func(in T) bool {
+		want, got, err := roundTrip(in)
+		if err != nil {
+			t.Logf("round trip of %+v returned error %v", in, err)
+			return false
+		}
+		if !proto.Equal(got, want) {
+			t.Logf("round trip of %+v = %+v", want, got)
+			return false
+		}
+		return true
+	}
+
+	if err := quick.Check(property, &quick.Config{MaxCount: 500}); err != nil {
+		t.Error(err)
+	}
+}
+
+func randomId(r *rand.Rand) int32 {
+	if r.Intn(4) == 0 {
+		return 0
+	}
+	return r.Int31n(1<<31-1) + 1
+}
+
+// randomTimestamp avoids 0001-01-01T00:00:00Z, the zero time, which stands for an unset timestamp
+func randomTimestamp(r *rand.Rand) *timestamppb.Timestamp {
+	if r.Intn(4) == 0 {
+		return nil
+	}
+
+	minSeconds := time.Date(1, 1, 1, 0, 0, 1, 0, time.UTC).Unix()
+	maxSeconds := time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC).Unix()
+	return &timestamppb.Timestamp{
+		Seconds: minSeconds + r.Int63n(maxSeconds-minSeconds+1),
+		Nanos:   r.Int31n(1e9),
+	}
+}
+
+func randomDuration(r *rand.Rand) *durationpb.Duration {
+	if r.Intn(4) == 0 {
+		return nil
+	}
+
+	duration := time.Duration(r.Int63())
+	if r.Intn(2) == 0 {
+		duration = -duration
+	}
+	return durationpb.New(duration)
+}
+
+func randomCallout(r *rand.Rand) *pbSchema.CallOutDetail {
+	return &pbSchema.CallOutDetail{
+		DetailId:    randomId(r),
+		CallId:      randomId(r),
+		AmbulanceId: randomId(r),
+		ActionTaken: randomString(r),
+		TimeSpent:   randomDuration(r),
+		Notes:       randomString(r),
+		CreatedAt:   randomTimestamp(r),
+	}
+}
+
+// randomLocation is never nil, an unset location reads back as 0, 0
+func randomLocation(r *rand.Rand) *pbSchema.Location {
+	return &pbSchema.Location{
+		Latitude:  r.Float64()*180 - 90,
+		Longitude: r.Float64()*360 - 180,
+	}
+}
+
+func randomEnum[T interface{ ToPb() (P, error) }, P any](r *rand.Rand, values []T) P {
+	value, err := values[r.Intn(len(values))].ToPb()
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
+
+// randomNhsNumber returns nine random digits followed by their modulus 11 check digit
+func randomNhsNumber(r *rand.Rand) string {
+	for {
+		digits := fmt.Sprintf("%09d", r.Int63n(1e9))
+
+		sum := 0
+		for i, digit := range digits {
+			sum += int(digit-'0') * (10 - i)
+		}
+		check := 11 - sum%11
+		if check == 11 {
+			check = 0
+		}
+		if check != 10 {
+			return fmt.Sprintf("%s%d", digits, check)
+		}
+	}
+}
+
+func randomString(r *rand.Rand) string {
+	value, _ := quick.Value(reflect.TypeOf(""), r)
+	return value.String()
+}
+
+// randomStrings leaves empty lists nil, as they are read back from the database
+func randomStrings(r *rand.Rand) []string {
+	var values []string
+	for n := r.Intn(4); n > 0; n-- {
+		values = append(values, randomString(r))
+	}
+	return values
+}
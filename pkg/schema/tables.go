@@ -3,8 +3,7 @@ package schema
 import (
 	pbSchema "github.com/jamieyoung5/kwikmedical-eventstream/pb"
 	"github.com/lib/pq"
-	"google.golang.org/protobuf/types/known/durationpb"
-	"google.golang.org/protobuf/types/known/timestamppb"
+	"strings"
 	"time"
 )
 
@@ -21,9 +20,8 @@ type Patient struct {
 }
 
 func (p *Patient) ToPb() *pbSchema.Patient {
-	var createdAtPb *timestamppb.Timestamp
-	if !p.CreatedAt.IsZero() {
-		createdAtPb = timestamppb.New(p.CreatedAt)
+	if p == nil {
+		return nil
 	}
 
 	return &pbSchema.Patient{
@@ -35,14 +33,13 @@ func (p *Patient) ToPb() *pbSchema.Patient {
 		Address:     p.Address,
 		PhoneNumber: p.PhoneNumber,
 		Email:       p.Email,
-		CreatedAt:   createdAtPb,
+		CreatedAt:   timestampToPb(p.CreatedAt),
 	}
 }
 
 type MedicalRecord struct {
 	RecordID    uint           `gorm:"primaryKey" json:"record_id"`
 	PatientID   uint           `gorm:"not null;constraint:OnDelete:CASCADE" json:"patient_id"`
-	CalloutIDs  pq.Int64Array  `gorm:"type:int[]" json:"callout_ids"` // For array, PostgreSQL specific
 	Conditions  pq.StringArray `gorm:"type:text[]" json:"conditions"`
 	Medications pq.StringArray `gorm:"type:text[]" json:"medications"`
 	Allergies   pq.StringArray `gorm:"type:text[]" json:"allergies"`
@@ -51,6 +48,10 @@ type MedicalRecord struct {
 }
 
 func (mr *MedicalRecord) ToPb(callouts []CallOutDetails) *pbSchema.MedicalRecord {
+	if mr == nil {
+		return nil
+	}
+
 	var calloutDetails []*pbSchema.CallOutDetail
 	for _, callout := range callouts {
 		calloutDetails = append(calloutDetails, callout.ToPb())
@@ -58,12 +59,119 @@ func (mr *MedicalRecord) ToPb(callouts []CallOutDetails) *pbSchema.MedicalRecord
 
 	return &pbSchema.MedicalRecord{
 		RecordId:    int32(mr.RecordID),
+		PatientId:   int32(mr.PatientID),
 		Callouts:    calloutDetails,
 		Conditions:  mr.Conditions,
 		Medications: mr.Medications,
 		Allergies:   mr.Allergies,
 		Notes:       mr.Notes,
-		LastUpdated: timestamppb.New(mr.LastUpdated),
+		LastUpdated: timestampToPb(mr.LastUpdated),
+	}
+}
+
+// MedicalCondition is a structured diagnosis on a medical record, coded with SNOMED CT where known and falling
+// back to the free text description otherwise. Its description is mirrored into MedicalRecord.Conditions.
+type MedicalCondition struct {
+	ConditionID uint       `gorm:"primaryKey;autoIncrement" json:"condition_id"`
+	RecordID    uint       `gorm:"not null;constraint:OnDelete:CASCADE" json:"record_id"`
+	SnomedCode  string     `gorm:"type:varchar(20)" json:"snomed_code"`
+	Description string     `gorm:"type:text;not null" json:"description"`
+	OnsetDate   *time.Time `gorm:"type:date" json:"onset_date"`
+	RecordedAt  time.Time  `gorm:"autoCreateTime" json:"recorded_at"`
+}
+
+func (mc *MedicalCondition) Key() string {
+	return clinicalKey(mc.SnomedCode, mc.Description)
+}
+
+func (mc *MedicalCondition) Display() string {
+	return strings.TrimSpace(mc.Description)
+}
+
+// MedicalMedication is a structured medication on a medical record, coded with dm+d where known. Its name and
+// dosage are mirrored into MedicalRecord.Medications.
+type MedicalMedication struct {
+	MedicationID uint       `gorm:"primaryKey;autoIncrement" json:"medication_id"`
+	RecordID     uint       `gorm:"not null;constraint:OnDelete:CASCADE" json:"record_id"`
+	DmdCode      string     `gorm:"type:varchar(20)" json:"dmd_code"`
+	Name         string     `gorm:"type:text;not null" json:"name"`
+	Dosage       string     `gorm:"type:text" json:"dosage"`
+	StartDate    *time.Time `gorm:"type:date" json:"start_date"`
+	RecordedAt   time.Time  `gorm:"autoCreateTime" json:"recorded_at"`
+}
+
+func (mm *MedicalMedication) Key() string {
+	return clinicalKey(mm.DmdCode, mm.Name)
+}
+
+func (mm *MedicalMedication) Display() string {
+	return strings.TrimSpace(strings.TrimSpace(mm.Name) + " " + strings.TrimSpace(mm.Dosage))
+}
+
+// MedicalAllergy is a structured allergy on a medical record, coded with SNOMED CT where known. Its substance
+// and severity are mirrored into MedicalRecord.Allergies.
+type MedicalAllergy struct {
+	AllergyID  uint            `gorm:"primaryKey;autoIncrement" json:"allergy_id"`
+	RecordID   uint            `gorm:"not null;constraint:OnDelete:CASCADE" json:"record_id"`
+	SnomedCode string          `gorm:"type:varchar(20)" json:"snomed_code"`
+	Substance  string          `gorm:"type:text;not null" json:"substance"`
+	Reaction   string          `gorm:"type:text" json:"reaction"`
+	Severity   AllergySeverity `gorm:"type:allergy_severity;default:'UNKNOWN_ALLERGY_SEVERITY'" json:"severity"`
+	OnsetDate  *time.Time      `gorm:"type:date" json:"onset_date"`
+	RecordedAt time.Time       `gorm:"autoCreateTime" json:"recorded_at"`
+}
+
+func (ma *MedicalAllergy) Key() string {
+	return clinicalKey(ma.SnomedCode, ma.Substance)
+}
+
+func (ma *MedicalAllergy) Display() string {
+	substance := strings.TrimSpace(ma.Substance)
+	if ma.Severity == "" || ma.Severity == UnknownAllergySeverity {
+		return substance
+	}
+	return substance + " (" + string(ma.Severity) + ")"
+}
+
+// ClinicalEntries holds the structured entries of a medical record
+type ClinicalEntries struct {
+	Conditions  []MedicalCondition  `json:"conditions"`
+	Medications []MedicalMedication `json:"medications"`
+	Allergies   []MedicalAllergy    `json:"allergies"`
+}
+
+// clinicalKey identifies equivalent entries, by code when coded and by case-insensitive text otherwise
+func clinicalKey(code string, text string) string {
+	if code = strings.TrimSpace(code); code != "" {
+		return "code:" + code
+	}
+	return "text:" + strings.ToLower(strings.TrimSpace(text))
+}
+
+// MedicalRecordVersion is an immutable snapshot of a medical record, written by a database trigger on every
+// insert or update of medical_records.
+type MedicalRecordVersion struct {
+	VersionID   uint           `gorm:"primaryKey;autoIncrement" json:"version_id"`
+	RecordID    uint           `gorm:"not null" json:"record_id"`
+	PatientID   uint           `gorm:"not null" json:"patient_id"`
+	Version     int            `gorm:"not null" json:"version"`
+	Conditions  pq.StringArray `gorm:"type:text[]" json:"conditions"`
+	Medications pq.StringArray `gorm:"type:text[]" json:"medications"`
+	Allergies   pq.StringArray `gorm:"type:text[]" json:"allergies"`
+	Notes       pq.StringArray `gorm:"type:text[]" json:"notes"`
+	ChangedBy   string         `gorm:"type:text" json:"changed_by"`
+	ChangedAt   time.Time      `json:"changed_at"`
+}
+
+func (mrv *MedicalRecordVersion) MedicalRecord() *MedicalRecord {
+	return &MedicalRecord{
+		RecordID:    mrv.RecordID,
+		PatientID:   mrv.PatientID,
+		Conditions:  mrv.Conditions,
+		Medications: mrv.Medications,
+		Allergies:   mrv.Allergies,
+		Notes:       mrv.Notes,
+		LastUpdated: mrv.ChangedAt,
 	}
 }
 
@@ -72,16 +180,14 @@ type CallOutDetails struct {
 	CallID      uint      `gorm:"not null;constraint:OnDelete:CASCADE" json:"call_id"`
 	AmbulanceID uint      `json:"ambulance_id"`
 	ActionTaken string    `gorm:"type:text" json:"action_taken"`
-	TimeSpent   string    `gorm:"type:interval" json:"time_spent"`
+	TimeSpent   *Interval `gorm:"type:interval" json:"time_spent"`
 	Notes       string    `gorm:"type:text" json:"notes"`
 	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
 }
 
 func (cd *CallOutDetails) ToPb() *pbSchema.CallOutDetail {
-	var timeSpent *durationpb.Duration
-	duration, err := time.ParseDuration(cd.TimeSpent)
-	if err == nil {
-		timeSpent = durationpb.New(duration)
+	if cd == nil {
+		return nil
 	}
 
 	return &pbSchema.CallOutDetail{
@@ -89,9 +195,9 @@ func (cd *CallOutDetails) ToPb() *pbSchema.CallOutDetail {
 		CallId:      int32(cd.CallID),
 		AmbulanceId: int32(cd.AmbulanceID),
 		ActionTaken: cd.ActionTaken,
-		TimeSpent:   timeSpent,
+		TimeSpent:   cd.TimeSpent.ToPb(),
 		Notes:       cd.Notes,
-		CreatedAt:   timestamppb.New(cd.CreatedAt),
+		CreatedAt:   timestampToPb(cd.CreatedAt),
 	}
 }
 
@@ -104,18 +210,69 @@ type EmergencyCall struct {
 	CallTime         time.Time           `gorm:"default:CURRENT_TIMESTAMP" json:"call_time"`
 	MedicalCondition string              `gorm:"type:text" json:"medical_condition"`
 	Location         Location            `gorm:"type:text" json:"location"`
-	Severity         InjurySeverity      `gorm:"type:injury_severity;default:'Low'" json:"severity"`
-	Status           EmergencyCallStatus `gorm:"type:emergency_call_status;default:'Pending'" json:"status"`
+	Severity         InjurySeverity      `gorm:"type:injury_severity;default:'LOW'" json:"severity"`
+	Status           EmergencyCallStatus `gorm:"type:emergency_call_status;default:'AMBULANCE_PENDING'" json:"status"`
+	ParentCallID     *uint               `gorm:"column:parent_call_id" json:"parent_call_id,omitempty"` // the first call about the same event
+	IncidentID       *uint               `gorm:"column:incident_id" json:"incident_id,omitempty"`
+}
+
+// ToPb fails with ErrUnknownEnumValue when the call's severity or status has no protobuf counterpart
+func (ec *EmergencyCall) ToPb() (*pbSchema.EmergencyCall, error) {
+	if ec == nil {
+		return nil, nil
+	}
+
+	severity, err := ec.Severity.ToPb()
+	if err != nil {
+		return nil, err
+	}
+	status, err := ec.Status.ToPb()
+	if err != nil {
+		return nil, err
+	}
+
+	return &pbSchema.EmergencyCall{
+		CallId:           int32(ec.CallID),
+		PatientId:        idToPb(ec.PatientID),
+		NhsNumber:        ec.NHSNumber,
+		CallerName:       ec.CallerName,
+		CallerPhone:      ec.CallerPhone,
+		CallTime:         timestampToPb(ec.CallTime),
+		MedicalCondition: ec.MedicalCondition,
+		Location:         ec.Location.ToPb(),
+		Severity:         severity,
+		Status:           status,
+	}, nil
 }
 
 type Ambulance struct {
 	AmbulanceID        uint            `gorm:"primaryKey" json:"ambulance_id"`
 	AmbulanceNumber    string          `gorm:"type:varchar(20);unique;not null" json:"ambulance_number"`
 	CurrentLocation    Location        `gorm:"type:point" json:"current_location"` // PostGIS POINT type
-	Status             AmbulanceStatus `gorm:"type:ambulance_status;default:'Available'" json:"status"`
+	Status             AmbulanceStatus `gorm:"type:ambulance_status;default:'UNKNOWN_AMBULANCE_STATUS'" json:"status"`
 	RegionalHospitalID *uint           `gorm:"constraint:OnDelete:SET NULL" json:"regional_hospital_id"`
 }
 
+// ToPb fails with ErrUnknownEnumValue when the ambulance's status has no protobuf counterpart
+func (a *Ambulance) ToPb() (*pbSchema.Ambulance, error) {
+	if a == nil {
+		return nil, nil
+	}
+
+	status, err := a.Status.ToPb()
+	if err != nil {
+		return nil, err
+	}
+
+	return &pbSchema.Ambulance{
+		AmbulanceId:        int32(a.AmbulanceID),
+		AmbulanceNumber:    a.AmbulanceNumber,
+		CurrentLocation:    a.CurrentLocation.ToPb(),
+		Status:             status,
+		RegionalHospitalId: idToPb(a.RegionalHospitalID),
+	}, nil
+}
+
 type AmbulanceRequest struct {
 	RequestID       uint           `gorm:"primaryKey;autoIncrement" json:"request_id"`
 	AmbulanceID     *uint          `gorm:"column:ambulance_id" json:"ambulance_id"`
@@ -124,25 +281,41 @@ type AmbulanceRequest struct {
 	Severity        InjurySeverity `gorm:"type:injury_severity" json:"severity"`
 	Location        Location       `gorm:"type:point" json:"location"` // PostGIS POINT type
 	Status          RequestStatus  `gorm:"type:request_status" json:"status"`
+	ClaimedBy       *string        `gorm:"column:claimed_by" json:"claimed_by,omitempty"`
+	ClaimedUntil    *time.Time     `gorm:"column:claimed_until" json:"claimed_until,omitempty"`
+	AcceptedAt      *time.Time     `json:"accepted_at,omitempty"`
+	ArrivedAt       *time.Time     `json:"arrived_at,omitempty"`
+	CompletedAt     *time.Time     `json:"completed_at,omitempty"`
 	CreatedAt       time.Time      `gorm:"autoCreateTime" json:"created_at"`
 	UpdatedAt       time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
 }
 
-func (aq *AmbulanceRequest) ToPb() *pbSchema.AmbulanceRequest {
+// ToPb fails with ErrUnknownEnumValue when the request's severity or status has no protobuf counterpart
+func (aq *AmbulanceRequest) ToPb() (*pbSchema.AmbulanceRequest, error) {
+	if aq == nil {
+		return nil, nil
+	}
+
+	severity, err := aq.Severity.ToPb()
+	if err != nil {
+		return nil, err
+	}
+	status, err := aq.Status.ToPb()
+	if err != nil {
+		return nil, err
+	}
 
 	return &pbSchema.AmbulanceRequest{
 		RequestId:       int32(aq.RequestID),
-		HospitalId:      int32(*aq.HospitalID),
+		AmbulanceId:     idToPb(aq.AmbulanceID),
+		HospitalId:      idToPb(aq.HospitalID),
 		EmergencyCallId: int32(aq.EmergencyCallID),
-		Severity:        pbSchema.InjurySeverity(pbSchema.InjurySeverity_value[string(aq.Severity)]),
-		Location: &pbSchema.Location{
-			Latitude:  aq.Location.Latitude,
-			Longitude: aq.Location.Longitude,
-		},
-		Status:    pbSchema.RequestStatus(pbSchema.RequestStatus_value[string(aq.Status)]),
-		CreatedAt: timestamppb.New(aq.CreatedAt),
-		UpdatedAt: timestamppb.New(aq.UpdatedAt),
-	}
+		Severity:        severity,
+		Location:        aq.Location.ToPb(),
+		Status:          status,
+		CreatedAt:       timestampToPb(aq.CreatedAt),
+		UpdatedAt:       timestampToPb(aq.UpdatedAt),
+	}, nil
 }
 
 type AmbulanceStaff struct {
@@ -156,6 +329,29 @@ type AmbulanceStaff struct {
 	IsActive    bool      `gorm:"default:true" json:"is_active"`
 }
 
+// ToPb fails with ErrUnknownEnumValue when the staff member's role has no protobuf counterpart
+func (as *AmbulanceStaff) ToPb() (*pbSchema.AmbulanceStaff, error) {
+	if as == nil {
+		return nil, nil
+	}
+
+	role, err := as.Role.ToPb()
+	if err != nil {
+		return nil, err
+	}
+
+	return &pbSchema.AmbulanceStaff{
+		StaffId:     int32(as.StaffID),
+		FirstName:   as.FirstName,
+		LastName:    as.LastName,
+		PhoneNumber: as.PhoneNumber,
+		Email:       as.Email,
+		Role:        role,
+		AmbulanceId: idToPb(as.AmbulanceID),
+		IsActive:    as.IsActive,
+	}, nil
+}
+
 type RegionalHospital struct {
 	HospitalID  uint      `gorm:"primaryKey;autoIncrement" json:"hospital_id"`
 	Name        string    `gorm:"type:varchar(100);not null" json:"name"`
@@ -168,17 +364,111 @@ type RegionalHospital struct {
 }
 
 func (rh *RegionalHospital) ToPb() *pbSchema.RegionalHospital {
+	if rh == nil {
+		return nil
+	}
+
 	return &pbSchema.RegionalHospital{
 		HospitalId:  int32(rh.HospitalID),
 		Name:        rh.Name,
 		Address:     rh.Address,
 		PhoneNumber: rh.PhoneNumber,
 		Email:       rh.Email,
-		Location: &pbSchema.Location{
-			Latitude:  rh.Location.Latitude,
-			Longitude: rh.Location.Longitude,
-		},
-		Capacity:  int32(rh.Capacity),
-		CreatedAt: timestamppb.New(rh.CreatedAt),
+		Location:    rh.Location.ToPb(),
+		Capacity:    int32(rh.Capacity),
+		CreatedAt:   timestampToPb(rh.CreatedAt),
 	}
 }
+
+type DuplicatePatientProposal struct {
+	ProposalID    uint            `gorm:"primaryKey;autoIncrement" json:"proposal_id"`
+	PatientID     uint            `gorm:"not null" json:"patient_id"`
+	DuplicateID   uint            `gorm:"not null" json:"duplicate_id"`
+	Confidence    float64         `json:"confidence"`
+	MatchedFields pq.StringArray  `gorm:"type:text[]" json:"matched_fields"`
+	Status        DuplicateStatus `gorm:"type:duplicate_status;default:'PROPOSED'" json:"status"`
+	CreatedAt     time.Time       `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time       `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// PatientMerge is the audit trail of a merge, keeping snapshots of everything that was changed so the
+// merge can be reverted until ExpiresAt.
+type PatientMerge struct {
+	MergeID          uint          `gorm:"primaryKey;autoIncrement" json:"merge_id"`
+	SurvivorID       uint          `gorm:"not null" json:"survivor_id"`
+	DuplicateID      uint          `gorm:"not null" json:"duplicate_id"`
+	DuplicatePatient string        `gorm:"type:jsonb" json:"duplicate_patient"`
+	DuplicateRecords string        `gorm:"type:jsonb" json:"duplicate_records"`
+	SurvivorRecord   string        `gorm:"type:jsonb" json:"survivor_record"`
+	DuplicateEntries string        `gorm:"type:jsonb" json:"duplicate_entries"`
+	MergedRecordID   uint          `json:"merged_record_id"`
+	MovedCallIDs     pq.Int64Array `gorm:"type:int[]" json:"moved_call_ids"`
+	MergedAt         time.Time     `gorm:"autoCreateTime" json:"merged_at"`
+	ExpiresAt        time.Time     `json:"expires_at"`
+	RevertedAt       *time.Time    `json:"reverted_at"`
+}
+
+// HospitalHandover records that a patient was handed over to a receiving hospital, along with the handover
+// package they were given.
+type HospitalHandover struct {
+	HandoverID   uint      `gorm:"primaryKey;autoIncrement" json:"handover_id"`
+	RequestID    uint      `gorm:"not null" json:"request_id"`
+	HospitalID   uint      `gorm:"not null" json:"hospital_id"`
+	CallID       uint      `gorm:"not null" json:"call_id"`
+	PatientID    *uint     `json:"patient_id"`
+	Package      string    `gorm:"type:jsonb" json:"package"`
+	HandedOverAt time.Time `gorm:"autoCreateTime" json:"handed_over_at"`
+}
+
+// Incident is a multi-casualty event that groups the calls made about it, along with their ambulance requests
+// and patients.
+type Incident struct {
+	IncidentID  uint           `gorm:"primaryKey;autoIncrement" json:"incident_id"`
+	Name        string         `gorm:"type:varchar(100);not null" json:"name"`
+	Description string         `gorm:"type:text" json:"description"`
+	Location    Location       `gorm:"type:jsonb" json:"location"`
+	Severity    InjurySeverity `gorm:"type:injury_severity;not null" json:"severity"`
+	Status      IncidentStatus `gorm:"type:incident_status;default:'DECLARED'" json:"status"`
+	DeclaredBy  string         `gorm:"type:varchar(100)" json:"declared_by"`
+	DeclaredAt  time.Time      `gorm:"autoCreateTime" json:"declared_at"`
+	StoodDownAt *time.Time     `json:"stood_down_at"`
+}
+
+// IncidentEvent is an entry in an incident's timeline
+type IncidentEvent struct {
+	EventID     uint              `gorm:"primaryKey;autoIncrement" json:"event_id"`
+	IncidentID  uint              `gorm:"not null" json:"incident_id"`
+	EventType   IncidentEventType `gorm:"type:varchar(50);not null" json:"event_type"`
+	Description string            `gorm:"type:text" json:"description"`
+	CallID      *uint             `json:"call_id,omitempty"`
+	RequestID   *uint             `json:"request_id,omitempty"`
+	AmbulanceID *uint             `json:"ambulance_id,omitempty"`
+	RecordedBy  string            `gorm:"type:varchar(100)" json:"recorded_by"`
+	OccurredAt  time.Time         `gorm:"autoCreateTime" json:"occurred_at"`
+}
+
+// MaintenanceWindow is a planned period an ambulance is out of service for. Windows that fall due while the
+// ambulance is on a call are deferred until it is free.
+type MaintenanceWindow struct {
+	WindowID     uint              `gorm:"primaryKey;autoIncrement" json:"window_id"`
+	AmbulanceID  uint              `gorm:"not null" json:"ambulance_id"`
+	PlannedStart time.Time         `gorm:"not null" json:"planned_start"`
+	PlannedEnd   time.Time         `gorm:"not null" json:"planned_end"`
+	Reason       string            `gorm:"type:text" json:"reason"`
+	Odometer     *int              `json:"odometer,omitempty"` // reading in kilometres
+	Status       MaintenanceStatus `gorm:"type:maintenance_status;default:'SCHEDULED'" json:"status"`
+	StartedAt    *time.Time        `json:"started_at,omitempty"`
+	CompletedAt  *time.Time        `json:"completed_at,omitempty"`
+	CreatedAt    time.Time         `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// StaffShift is a period a member of staff is rostered to crew an ambulance in a role
+type StaffShift struct {
+	ShiftID     uint      `gorm:"primaryKey;autoIncrement" json:"shift_id"`
+	StaffID     uint      `gorm:"not null" json:"staff_id"`
+	AmbulanceID uint      `gorm:"not null" json:"ambulance_id"`
+	Role        StaffRole `gorm:"type:staff_role;not null" json:"role"`
+	StartsAt    time.Time `gorm:"not null" json:"starts_at"`
+	EndsAt      time.Time `gorm:"not null" json:"ends_at"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
@@ -5,6 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/jamieyoung5/kwikmedical-eventstream/pb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Location struct {
@@ -21,8 +25,17 @@ func (loc Location) Value() (driver.Value, error) {
 }
 
 func (loc *Location) Scan(value interface{}) error {
-	bytes, ok := value.([]byte)
-	if !ok {
+	var bytes []byte
+	switch v := value.(type) {
+	case []byte:
+		bytes = v
+	case string:
+		// emergency_calls stores its location as text rather than jsonb
+		bytes = []byte(v)
+	case nil:
+		*loc = Location{}
+		return nil
+	default:
 		return fmt.Errorf("failed to unmarshal location: expected []byte, got %T", value)
 	}
 
@@ -34,8 +47,274 @@ func (loc *Location) Scan(value interface{}) error {
 }
 
 func LocationFromPb(loc *pb.Location) Location {
+	if loc == nil {
+		return Location{}
+	}
+
 	return Location{
 		Latitude:  loc.Latitude,
 		Longitude: loc.Longitude,
 	}
 }
+
+func (loc Location) ToPb() *pb.Location {
+	return &pb.Location{
+		Latitude:  loc.Latitude,
+		Longitude: loc.Longitude,
+	}
+}
+
+// Interval is a Postgres INTERVAL holding a duration. Postgres stores intervals to the microsecond, so a
+// duration round-trips exactly as long as it has no finer precision than that. Intervals written by postgres
+// with days, months or years are read with a day as 24 hours, a month as 30 days and a year as 365.25 days,
+// the same as extract(epoch FROM interval).
+type Interval time.Duration
+
+const (
+	intervalDay   = 24 * time.Hour
+	intervalMonth = 30 * intervalDay
+	intervalYear  = time.Duration(365.25 * float64(intervalDay))
+)
+
+func IntervalFromPb(duration *durationpb.Duration) *Interval {
+	if duration == nil {
+		return nil
+	}
+	interval := Interval(duration.AsDuration())
+	return &interval
+}
+
+func (i *Interval) ToPb() *durationpb.Duration {
+	if i == nil {
+		return nil
+	}
+	return durationpb.New(i.Duration())
+}
+
+func (i Interval) Duration() time.Duration {
+	return time.Duration(i)
+}
+
+// Value writes the interval in the default postgres output style, e.g. "25:00:00.5" or "-00:01:00"
+func (i Interval) Value() (driver.Value, error) {
+	duration := i.Duration().Truncate(time.Microsecond)
+
+	sign := ""
+	if duration < 0 {
+		sign = "-"
+		duration = -duration
+	}
+
+	hours := duration / time.Hour
+	minutes := (duration % time.Hour) / time.Minute
+	micros := (duration % time.Minute) / time.Microsecond
+
+	value := fmt.Sprintf("%s%02d:%02d:%02d", sign, int64(hours), int64(minutes), int64(micros/1e6))
+	if micros%1e6 != 0 {
+		value += strings.TrimRight(fmt.Sprintf(".%06d", int64(micros%1e6)), "0")
+	}
+
+	return value, nil
+}
+
+// Scan reads intervals in the postgres and iso_8601 interval styles
+func (i *Interval) Scan(value interface{}) error {
+	var text string
+	switch v := value.(type) {
+	case []byte:
+		text = string(v)
+	case string:
+		text = v
+	case nil:
+		*i = 0
+		return nil
+	default:
+		return fmt.Errorf("failed to scan interval: expected string, got %T", value)
+	}
+
+	var (
+		duration time.Duration
+		err      error
+	)
+	if strings.HasPrefix(text, "P") || strings.HasPrefix(text, "-P") {
+		duration, err = parseIsoInterval(text)
+	} else {
+		duration, err = parsePostgresInterval(text)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to scan interval %q: %w", text, err)
+	}
+
+	*i = Interval(duration)
+	return nil
+}
+
+func (i Interval) String() string {
+	return i.Duration().String()
+}
+
+// parsePostgresInterval reads e.g. "1 year 2 mons -3 days 04:05:06.789"
+func parsePostgresInterval(text string) (time.Duration, error) {
+	var duration time.Duration
+
+	fields := strings.Fields(text)
+	for n := 0; n < len(fields); n++ {
+		field := fields[n]
+		if strings.Contains(field, ":") {
+			clock, err := parseIntervalClock(field)
+			if err != nil {
+				return 0, err
+			}
+			duration += clock
+			continue
+		}
+
+		if n+1 >= len(fields) {
+			return 0, fmt.Errorf("missing unit after %q", field)
+		}
+		amount, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+
+		n++
+		switch strings.TrimSuffix(fields[n], "s") {
+		case "year":
+			duration += time.Duration(amount) * intervalYear
+		case "mon":
+			duration += time.Duration(amount) * intervalMonth
+		case "day":
+			duration += time.Duration(amount) * intervalDay
+		default:
+			return 0, fmt.Errorf("unknown interval unit %q", fields[n])
+		}
+	}
+
+	return duration, nil
+}
+
+// parseIntervalClock reads "[-]hh:mm:ss[.ffffff]", where the hours are not limited to 24
+func parseIntervalClock(clock string) (time.Duration, error) {
+	negative := strings.HasPrefix(clock, "-")
+	clock = strings.TrimLeft(clock, "+-")
+
+	parts := strings.Split(clock, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid interval time %q", clock)
+	}
+
+	hours, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	minutes, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	seconds, err := parseIntervalSeconds(parts[2])
+	if err != nil {
+		return 0, err
+	}
+
+	duration := time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + seconds
+	if negative {
+		duration = -duration
+	}
+	return duration, nil
+}
+
+// parseIntervalSeconds reads seconds with up to microsecond fractions without going through a float
+func parseIntervalSeconds(text string) (time.Duration, error) {
+	whole, fraction, _ := strings.Cut(text, ".")
+
+	seconds, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	duration := time.Duration(seconds) * time.Second
+
+	if fraction != "" {
+		if len(fraction) > 9 {
+			fraction = fraction[:9]
+		}
+		nanos, err := strconv.ParseInt(fraction+strings.Repeat("0", 9-len(fraction)), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		if strings.HasPrefix(whole, "-") {
+			nanos = -nanos
+		}
+		duration += time.Duration(nanos)
+	}
+
+	return duration, nil
+}
+
+// parseIsoInterval reads e.g. "P1Y2M3DT4H5M6.789S", where each component may be negative
+func parseIsoInterval(text string) (time.Duration, error) {
+	negative := strings.HasPrefix(text, "-")
+	text = strings.TrimPrefix(strings.TrimPrefix(text, "-"), "P")
+
+	var (
+		duration time.Duration
+		inTime   bool
+		number   strings.Builder
+	)
+	for _, char := range text {
+		switch {
+		case char == 'T':
+			inTime = true
+		case char == '-' || char == '.' || (char >= '0' && char <= '9'):
+			number.WriteRune(char)
+		default:
+			if number.Len() == 0 {
+				return 0, fmt.Errorf("missing amount before %q", char)
+			}
+			if inTime && char == 'S' {
+				seconds, err := parseIntervalSeconds(number.String())
+				if err != nil {
+					return 0, err
+				}
+				duration += seconds
+				number.Reset()
+				continue
+			}
+
+			amount, err := strconv.ParseInt(number.String(), 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			number.Reset()
+
+			unit, err := isoIntervalUnit(char, inTime)
+			if err != nil {
+				return 0, err
+			}
+			duration += time.Duration(amount) * unit
+		}
+	}
+
+	if negative {
+		duration = -duration
+	}
+	return duration, nil
+}
+
+func isoIntervalUnit(designator rune, inTime bool) (time.Duration, error) {
+	switch {
+	case !inTime && designator == 'Y':
+		return intervalYear, nil
+	case !inTime && designator == 'M':
+		return intervalMonth, nil
+	case !inTime && designator == 'W':
+		return 7 * intervalDay, nil
+	case !inTime && designator == 'D':
+		return intervalDay, nil
+	case inTime && designator == 'H':
+		return time.Hour, nil
+	case inTime && designator == 'M':
+		return time.Minute, nil
+	default:
+		return 0, fmt.Errorf("unknown interval designator %q", designator)
+	}
+}
@@ -0,0 +1,129 @@
+package schema
+
+import (
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+func TestIntervalScan(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  time.Duration
+	}{
+		{name: "zero", value: "00:00:00", want: 0},
+		{name: "clock", value: "01:02:03", want: time.Hour + 2*time.Minute + 3*time.Second},
+		{name: "hours past a day", value: "25:00:00", want: 25 * time.Hour},
+		{name: "negative clock", value: "-00:01:00", want: -time.Minute},
+		{name: "fractional seconds", value: "00:00:01.5", want: 1500 * time.Millisecond},
+		{name: "microseconds", value: "00:00:00.000001", want: time.Microsecond},
+		{name: "negative fractional seconds", value: "-00:00:00.25", want: -250 * time.Millisecond},
+		{name: "one day", value: "1 day", want: intervalDay},
+		{name: "days and clock", value: "3 days 04:05:06.789", want: 3*intervalDay + 4*time.Hour + 5*time.Minute + 6789*time.Millisecond},
+		{name: "negative days with positive clock", value: "-1 days +02:00:00", want: -22 * time.Hour},
+		{name: "positive days with negative clock", value: "1 day -02:00:00", want: 22 * time.Hour},
+		{name: "years and months", value: "1 year 2 mons", want: intervalYear + 2*intervalMonth},
+		{name: "every component negative", value: "-1 years -2 mons -3 days -04:05:06", want: -(intervalYear + 2*intervalMonth + 3*intervalDay + 4*time.Hour + 5*time.Minute + 6*time.Second)},
+		{name: "bytes", value: []byte("00:10:00"), want: 10 * time.Minute},
+		{name: "null", value: nil, want: 0},
+		{name: "iso zero", value: "PT0S", want: 0},
+		{name: "iso every component", value: "P1Y2M3DT4H5M6.789S", want: intervalYear + 2*intervalMonth + 3*intervalDay + 4*time.Hour + 5*time.Minute + 6789*time.Millisecond},
+		{name: "iso weeks", value: "P2W", want: 14 * intervalDay},
+		{name: "iso minutes are not months", value: "PT5M", want: 5 * time.Minute},
+		{name: "iso negative", value: "-P1D", want: -intervalDay},
+		{name: "iso mixed signs", value: "P-1DT2H", want: -22 * time.Hour},
+		{name: "iso negative fractional seconds", value: "PT-0.5S", want: -500 * time.Millisecond},
+		{name: "iso microseconds", value: "PT1.000001S", want: time.Second + time.Microsecond},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			interval := Interval(time.Hour)
+			if err := interval.Scan(test.value); err != nil {
+				t.Fatalf("Scan(%q) returned error %v", test.value, err)
+			}
+			if interval.Duration() != test.want {
+				t.Errorf("Scan(%q) = %s, want %s", test.value, interval.Duration(), test.want)
+			}
+		})
+	}
+}
+
+func TestIntervalScanInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+	}{
+		{name: "amount without a unit", value: "1"},
+		{name: "unknown unit", value: "1 week"},
+		{name: "clock without seconds", value: "01:02"},
+		{name: "not a number", value: "one day"},
+		{name: "iso unknown designator", value: "P1X"},
+		{name: "iso designator without an amount", value: "PTH"},
+		{name: "iso hours outside the time part", value: "P1H"},
+		{name: "not text", value: 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var interval Interval
+			if err := interval.Scan(test.value); err == nil {
+				t.Errorf("Scan(%v) = %s, want an error", test.value, interval.Duration())
+			}
+		})
+	}
+}
+
+func TestIntervalValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration time.Duration
+		want     string
+	}{
+		{name: "zero", duration: 0, want: "00:00:00"},
+		{name: "clock", duration: time.Hour + 2*time.Minute + 3*time.Second, want: "01:02:03"},
+		{name: "hours past a day", duration: 25*time.Hour + 500*time.Millisecond, want: "25:00:00.5"},
+		{name: "negative", duration: -time.Minute, want: "-00:01:00"},
+		{name: "negative fractional seconds", duration: -250 * time.Millisecond, want: "-00:00:00.25"},
+		{name: "microseconds", duration: time.Microsecond, want: "00:00:00.000001"},
+		{name: "finer than a microsecond is truncated", duration: time.Second + 999*time.Nanosecond, want: "00:00:01"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, err := Interval(test.duration).Value()
+			if err != nil {
+				t.Fatalf("Value(%s) returned error %v", test.duration, err)
+			}
+			if value != test.want {
+				t.Errorf("Value(%s) = %q, want %q", test.duration, value, test.want)
+			}
+		})
+	}
+}
+
+func TestIntervalValueScanRoundTrip(t *testing.T) {
+	roundTrips := func(duration time.Duration) bool {
+		value, err := Interval(duration).Value()
+		if err != nil {
+			return false
+		}
+
+		var interval Interval
+		if err = interval.Scan(value); err != nil {
+			return false
+		}
+		return interval.Duration() == duration.Truncate(time.Microsecond)
+	}
+
+	for _, duration := range []time.Duration{0, time.Microsecond, -time.Microsecond, 36*time.Hour + 1500*time.Millisecond, -(3*time.Hour + 250*time.Millisecond)} {
+		if !roundTrips(duration) {
+			t.Errorf("%s did not round-trip through Value and Scan", duration)
+		}
+	}
+
+	if err := quick.Check(roundTrips, nil); err != nil {
+		t.Error(err)
+	}
+}